@@ -0,0 +1,264 @@
+package xap_trello
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// the first retry of a failed push, doubled on each failure up to ARCHIVE_RETRY_MAX
+	ARCHIVE_RETRY_MIN = 30 * time.Second
+	ARCHIVE_RETRY_MAX = 30 * time.Minute
+)
+
+// ArchiverState is the queue of the archiver, served by GET /api/status.
+type ArchiverState struct {
+	// the period of the background archive, empty when it only runs at rollover
+	Schedule string `json:"schedule"`
+	// files changed since the last commit
+	Pending []string `json:"pending"`
+	// a commit is waiting to be pushed
+	PushPending bool      `json:"push_pending"`
+	LastCommit  time.Time `json:"last_commit"`
+	LastPush    time.Time `json:"last_push"`
+	LastError   string    `json:"last_error,omitempty"`
+	// failed runs in a row, the next run is backed off accordingly
+	Failures int       `json:"failures"`
+	NextRun  time.Time `json:"next_run"`
+}
+
+// Archiver commits the changed sprint files of ARCHIVE_DIR and pushes them, changes made between
+// two runs are coalesced into one commit.
+type Archiver struct {
+	config ArchiveConfig
+	// the local clone, ARCHIVE_DIR
+	local    string
+	interval time.Duration
+	// the dirLock of local, the git operations of a repository can not overlap
+	work  *sync.Mutex
+	state sync.Mutex
+	// files to commit, a set
+	pending     map[string]bool
+	pushPending bool
+	lastCommit  time.Time
+	lastPush    time.Time
+	lastError   string
+	failures    int
+	nextRun     time.Time
+	done        chan struct{}
+}
+
+// NewArchiver returns an archiver of config, with a schedule it also runs in the background.
+func NewArchiver(config ArchiveConfig) (*Archiver, error) {
+	archiver := newArchiver(config)
+	if config.Schedule != "" {
+		interval, err := time.ParseDuration(config.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("bad archive schedule %q: %s", config.Schedule, err.Error())
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("bad archive schedule %q: must be positive", config.Schedule)
+		}
+		archiver.interval = interval
+		go archiver.loop()
+	}
+	return archiver, nil
+}
+
+// newArchiver returns an archiver of config that only runs when flushed.
+func newArchiver(config ArchiveConfig) *Archiver {
+	return &Archiver{config: config, local: ARCHIVE_DIR, work: dirLock(ARCHIVE_DIR), pending: map[string]bool{}, done: make(chan struct{})}
+}
+
+var (
+	dirLocks      = map[string]*sync.Mutex{}
+	dirLocksMutex sync.Mutex
+)
+
+// dirLock is the lock of the local clone at dir, held by the runs of its archivers and by the code
+// writing its files, so a run never commits a half written file or resets a newer one.
+func dirLock(dir string) *sync.Mutex {
+	dirLocksMutex.Lock()
+	defer dirLocksMutex.Unlock()
+	dir = filepath.Clean(dir)
+	if _, ok := dirLocks[dir]; !ok {
+		dirLocks[dir] = &sync.Mutex{}
+	}
+	return dirLocks[dir]
+}
+
+// Add queues path, relative to the root of the clone, for the next run.
+func (a *Archiver) Add(path string) {
+	a.state.Lock()
+	defer a.state.Unlock()
+	a.pending[path] = true
+}
+
+// requestPush makes the next run push even when nothing changed, commits of a previous run may be waiting.
+func (a *Archiver) requestPush() {
+	a.state.Lock()
+	defer a.state.Unlock()
+	a.pushPending = true
+}
+
+// Stop ends the background runs.
+func (a *Archiver) Stop() {
+	close(a.done)
+}
+
+func (a *Archiver) loop() {
+	for {
+		wait := a.interval
+		a.state.Lock()
+		if 0 < a.failures {
+			if backoff := retryDelay(a.failures); backoff < wait {
+				wait = backoff
+			}
+		}
+		a.nextRun = time.Now().Add(wait)
+		a.state.Unlock()
+		select {
+		case <-a.done:
+			return
+		case <-time.After(wait):
+		}
+		if err := a.Flush(); err != nil {
+			log.Printf("Error %q, while archiving\n", err.Error())
+		}
+	}
+}
+
+func retryDelay(failures int) time.Duration {
+	delay := ARCHIVE_RETRY_MIN
+	for i := 1; i < failures && delay < ARCHIVE_RETRY_MAX; i++ {
+		delay *= 2
+	}
+	if ARCHIVE_RETRY_MAX < delay {
+		return ARCHIVE_RETRY_MAX
+	}
+	return delay
+}
+
+// Flush commits the pending files, unless none of them changed, and pushes what was not pushed yet.
+func (a *Archiver) Flush() error {
+	a.work.Lock()
+	defer a.work.Unlock()
+
+	a.state.Lock()
+	files := []string{}
+	for path := range a.pending {
+		files = append(files, path)
+	}
+	a.pending = map[string]bool{}
+	pushPending := a.pushPending
+	a.state.Unlock()
+	sort.Strings(files)
+	if len(files) == 0 && !pushPending {
+		return nil
+	}
+
+	committed, err := a.run(files, pushPending)
+	a.state.Lock()
+	defer a.state.Unlock()
+	if committed {
+		a.lastCommit = time.Now()
+		a.pushPending = true
+	}
+	if err != nil {
+		if !committed {
+			// retried with the files changed meanwhile
+			for _, path := range files {
+				a.pending[path] = true
+			}
+		}
+		a.failures++
+		a.lastError = err.Error()
+		return err
+	}
+	a.failures = 0
+	a.lastError = ""
+	if a.pushPending {
+		a.pushPending = false
+		a.lastPush = time.Now()
+	}
+	return nil
+}
+
+// run commits files when they changed, then rebases and pushes when something is waiting to be pushed.
+func (a *Archiver) run(files []string, pushPending bool) (committed bool, err error) {
+	git, err := NewArchiveRepository(a.config, a.local)
+	if err != nil {
+		return false, err
+	}
+	if err := git.Init(); err != nil {
+		return false, err
+	}
+	if 0 < len(files) {
+		if err := git.Add(files...); err != nil {
+			return false, err
+		}
+		staged, err := git.Staged()
+		if err != nil {
+			return false, err
+		}
+		if staged {
+			message := fmt.Sprintf("Automatic update of file %s on %v", files[0], time.Now())
+			if 1 < len(files) {
+				message = fmt.Sprintf("Automatic update of %d files on %v", len(files), time.Now())
+			}
+			if err := git.Commit(message); err != nil {
+				return false, err
+			}
+			committed = true
+		} else {
+			log.Printf("archive of %v skipped, nothing changed\n", files)
+		}
+	}
+	if !committed && !pushPending {
+		return false, nil
+	}
+	err = git.Rebase()
+	if conflict, ok := err.(*GitConflictError); ok {
+		// the commit stays local, it is pushed by the next run once the conflict is resolved
+		log.Printf("not pushing %v, the archive has conflicting changes: %s\n", files, conflict.Error())
+		return committed, err
+	}
+	if err != nil {
+		return committed, err
+	}
+	return committed, git.Push()
+}
+
+// Restore runs RestoreArchive, it does not overlap a run.
+func (a *Archiver) Restore() (*RestoreReport, error) {
+	a.work.Lock()
+	defer a.work.Unlock()
+	// local changes may have been committed by the restore
+	a.requestPush()
+	return RestoreArchive(a.config)
+}
+
+// State returns a snapshot of the queue.
+func (a *Archiver) State() ArchiverState {
+	a.state.Lock()
+	defer a.state.Unlock()
+	state := ArchiverState{
+		Schedule:    a.config.Schedule,
+		Pending:     []string{},
+		PushPending: a.pushPending,
+		LastCommit:  a.lastCommit,
+		LastPush:    a.lastPush,
+		LastError:   a.lastError,
+		Failures:    a.failures,
+		NextRun:     a.nextRun,
+	}
+	for path := range a.pending {
+		state.Pending = append(state.Pending, path)
+	}
+	sort.Strings(state.Pending)
+	return state
+}
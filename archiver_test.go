@@ -0,0 +1,78 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func TestArchiverDoesNotLoseAConcurrentSave(t *testing.T) {
+	defer inTempDir(t)()
+	remote, err := filepath.Abs("remote.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	archiver := newArchiver(ArchiveConfig{Remote: remote, Auth: GitAuthConfig{Token: "x"}})
+	b := &Burndown{Archiver: archiver, BurnDownData: BurnDownData{Sprint: &Sprint{Name: "12.1-M7", Start: time.Date(2016, 11, 27, 0, 0, 0, 0, time.UTC)}}}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			b.TrelloEvents = append(b.TrelloEvents, TrelloState{Done: i})
+			if err := b.save(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		archiver.requestPush()
+		if err := archiver.Flush(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	if err := archiver.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := json.MarshalIndent(b.BurnDownData, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := ioutil.ReadFile(filepath.Join(ARCHIVE_DIR, b.archivePath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(local) != string(saved) {
+		t.Errorf("the sprint file is not the last save")
+	}
+	repository, err := git.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repository.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repository.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := commit.File(b.archivePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := file.Contents(); err != nil || content != string(saved) {
+		t.Errorf("the archive does not have the last save (%v)", err)
+	}
+}
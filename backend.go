@@ -0,0 +1,42 @@
+package xap_trello
+
+import (
+	"fmt"
+	"github.com/barakb/xap-trello/fake"
+	"log"
+	"net/http"
+)
+
+const (
+	BackendLive = "live"
+	BackendFake = "fake"
+)
+
+// set when running against the fake backend
+var jiraConfigOverride *JiraConfig
+
+// SelectBackend points the Trello and Jira clients at the live services or at in process fake servers
+// seeded from the fixtures directory.
+func SelectBackend(backend, fixtures string) error {
+	switch backend {
+	case "", BackendLive:
+		return nil
+	case BackendFake:
+		servers, err := fake.Start(fixtures)
+		if err != nil {
+			return err
+		}
+		http.DefaultTransport = servers.Transport(http.DefaultTransport)
+		jiraConfigOverride = &JiraConfig{Url: servers.Jira.URL, Auth: JiraAuthConfig{Type: JiraAuthBasic, User: "fake", Token: "fake"}}
+		log.Printf("Using fake Trello at %s and fake Jira at %s\n", servers.Trello.URL, servers.Jira.URL)
+		return nil
+	}
+	return fmt.Errorf("unknown backend %q, expected %s or %s", backend, BackendLive, BackendFake)
+}
+
+func jiraConfig() JiraConfig {
+	if jiraConfigOverride != nil {
+		return *jiraConfigOverride
+	}
+	return ReadConfig().Jira
+}
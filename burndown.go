@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -29,6 +30,17 @@ type Burndown struct {
 	done     chan struct{}
 	commands chan BurndownCommand
 	Trello   *Trello
+	// set while a sprint rollover rearranges the board, written by the scan loop under RWMutex
+	scanPaused bool
+	// the burndown follows the active sprint of Source
+	Source          SprintSource
+	lastSprintCheck time.Time
+	// archives data/ in the background, see ArchiveConfig.Schedule
+	Archiver *Archiver
+	// publishes the static site of the sprints, see PagesConfig
+	Pages *PagesPublisher
+	// what the startup restore found in the archive, guarded by RWMutex
+	restore *RestoreReport
 }
 
 type Sprint struct {
@@ -76,7 +88,19 @@ func NewBurnDown() *Burndown {
 	if err != nil {
 		log.Fatal(err)
 	}
-	burndown := &Burndown{Trello: xapTrello, commands: make(chan BurndownCommand), BurnDownData: BurnDownData{Sprint: ReadSprint()}}
+	source, err := NewSprintSource(ReadConfig().Sprint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	archiver, err := NewArchiver(ReadConfig().Archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pages, err := NewPagesPublisher(ReadConfig().Pages, ReadConfig().Archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	burndown := &Burndown{Trello: xapTrello, commands: make(chan BurndownCommand), Source: source, Archiver: archiver, Pages: pages}
 	go burndown.ScanLoop(10 * time.Second) //todo remove
 	return burndown
 }
@@ -131,6 +155,11 @@ func (b *Burndown) statePerDay(events []TrelloState) map[string]TrelloState {
 }
 
 func (b *Burndown) createSprint(timeline map[string]TrelloState) (s *SprintStatus) {
+	return b.createSprintAt(timeline, time.Now())
+}
+
+// createSprintAt is the status of the sprint as seen on the day of now, the end of a past sprint shows all of it.
+func (b *Burndown) createSprintAt(timeline map[string]TrelloState, now time.Time) (s *SprintStatus) {
 	order := []string{}
 	m := map[string]bool{}
 	date := b.Sprint.Start
@@ -145,7 +174,7 @@ func (b *Burndown) createSprint(timeline map[string]TrelloState) (s *SprintStatu
 	order = append(order, dayStr)
 	m[dayStr] = true
 
-	s = &SprintStatus{Name: b.Sprint.Name, Today: indexOf(order, toDayStr(time.Now())), Days: []Day{}}
+	s = &SprintStatus{Name: b.Sprint.Name, Today: indexOf(order, toDayStr(now)), Days: []Day{}}
 	firstDay, err := b.findFirstFilledDay()
 	if err != nil {
 		if 0 < len(b.TrelloEvents) {
@@ -229,11 +258,19 @@ func (b *Burndown) scanOnce() (res TrelloState, err error) {
 		return res, err
 	}
 
+	var pulls *PullRequestRegistry
+	if ReadConfig().GitHub.CodeComplete {
+		if pulls, err = ReadPullRequestLinks(); err != nil {
+			log.Printf("Error %q, while reading the pull requests, counting the Done list as is\n", err.Error())
+		}
+	}
 	for index, trelloList := range trelloLists {
-		if index == 0 {
+		if index == 0 && pulls != nil {
+			res.Done, res.InProgress = sumCodeCompletePoints(trelloList, pulls)
+		} else if index == 0 {
 			res.Done = sumPoints(trelloList)
 		} else if index == 1 {
-			res.InProgress = sumPoints(trelloList)
+			res.InProgress += sumPoints(trelloList)
 		} else if index == 2 {
 			res.Planned = sumPoints(trelloList)
 		} else {
@@ -245,15 +282,21 @@ func (b *Burndown) scanOnce() (res TrelloState, err error) {
 }
 
 func (b *Burndown) ScanLoop(delay time.Duration) {
-	b.load()
-	compressedTimeline := b.compressTimeline()
-	sprintStatus := b.createSprint(compressedTimeline)
-	sprintStatus.Version = b.Version
-	b.Version = b.Version + 1
-	b.RWMutex.Lock()
-	b.SprintStatus = *sprintStatus
-	b.RWMutex.Unlock()
+	b.restoreArchive()
+	b.restorePause()
 	for {
+		b.followSprint()
+		if b.scanPaused || b.Sprint == nil {
+			select {
+			case <-b.done:
+				log.Println("ScanLoop exiting")
+				return
+			case cmd := <-b.commands:
+				cmd(b)
+			case <-time.After(delay):
+			}
+			continue
+		}
 		sprintState, err := b.scanOnce()
 		if err != nil {
 			log.Fatalf("got error %q, while calling burndown.ScanOnce()", err.Error())
@@ -264,13 +307,7 @@ func (b *Burndown) ScanLoop(delay time.Duration) {
 		if len(b.TrelloEvents) == 0 || !sprintState.sameAs(b.TrelloEvents[len(b.TrelloEvents)-1]) {
 			b.TrelloEvents = append(b.TrelloEvents, sprintState)
 			log.Printf("Timeline changed %v\n", b.TrelloEvents)
-			compressedTimeline := b.compressTimeline()
-			sprintStatus := b.createSprint(compressedTimeline)
-			sprintStatus.Version = b.Version
-			b.Version = b.Version + 1
-			b.RWMutex.Lock()
-			b.SprintStatus = *sprintStatus
-			b.RWMutex.Unlock()
+			b.updateStatus()
 			err := b.save()
 			if err != nil {
 				log.Printf("Error %q, while saving\n", err.Error())
@@ -288,12 +325,110 @@ func (b *Burndown) ScanLoop(delay time.Duration) {
 	}
 }
 
+func (b *Burndown) updateStatus() {
+	compressedTimeline := b.compressTimeline()
+	sprintStatus := b.createSprint(compressedTimeline)
+	sprintStatus.Version = b.Version
+	b.Version = b.Version + 1
+	b.RWMutex.Lock()
+	b.SprintStatus = *sprintStatus
+	b.RWMutex.Unlock()
+}
+
+// followSprint moves the burndown to the active sprint of its source, the data of the sprint it
+// leaves is saved first. A sprint whose dates changed keeps its timeline.
+func (b *Burndown) followSprint() {
+	if b.Source == nil || b.scanPaused || time.Since(b.lastSprintCheck) < SPRINT_CHECK_INTERVAL {
+		return
+	}
+	b.lastSprintCheck = time.Now()
+	sprint, err := b.Source.ActiveSprint()
+	if err != nil {
+		log.Printf("Error %q, while reading the active sprint\n", err.Error())
+		return
+	}
+	if sprint == nil || sprint.sameAs(b.Sprint) {
+		return
+	}
+	if b.Sprint != nil && b.Sprint.Name == sprint.Name {
+		log.Printf("Sprint %s moved to %s - %s\n", sprint.Name, toDayStr(sprint.Start), toDayStr(sprint.End))
+		b.Sprint = sprint
+		b.updateStatus()
+		return
+	}
+	if b.Sprint != nil {
+		if err := b.save(); err != nil {
+			log.Printf("Error %q, while saving sprint %s, staying on it\n", err.Error(), b.Sprint.Name)
+			return
+		}
+	}
+	log.Printf("Following sprint %s\n", sprint.Name)
+	b.resetSprint(sprint)
+	if err := b.load(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error %q, while loading sprint %s\n", err.Error(), sprint.Name)
+	}
+	// the saved data carries the sprint it was saved with
+	b.Sprint = sprint
+	b.updateStatus()
+}
+
+// restoreArchive syncs data/ with the archive before the first sprint is loaded, a fresh
+// container starts with the archived timeline.
+func (b *Burndown) restoreArchive() {
+	if b.Archiver == nil {
+		return
+	}
+	report, err := b.Archiver.Restore()
+	if err != nil {
+		log.Printf("Error %q, while restoring from the archive\n", err.Error())
+	}
+	b.RWMutex.Lock()
+	b.restore = report
+	b.RWMutex.Unlock()
+}
+
+// checkSprint makes the scan loop ask the source for the active sprint right away.
+func (b *Burndown) checkSprint() error {
+	return b.Exec(func(b *Burndown) error {
+		b.lastSprintCheck = time.Time{}
+		return nil
+	})
+}
+
 func (b *Burndown) GetSprintStatus() *SprintStatus {
 	b.RWMutex.RLock()
 	defer b.RWMutex.RUnlock()
 	return &b.SprintStatus
 }
 
+// BurndownStatus is served by GET /api/status.
+type BurndownStatus struct {
+	Sprint  string `json:"sprint"`
+	Version int    `json:"version"`
+	// the scan is paused by a rollover
+	Paused  bool           `json:"paused"`
+	Archive *ArchiverState `json:"archive,omitempty"`
+	Restore *RestoreReport `json:"restore,omitempty"`
+	Pages   *ArchiverState `json:"pages,omitempty"`
+}
+
+func (b *Burndown) Status() BurndownStatus {
+	sprintStatus := b.GetSprintStatus()
+	status := BurndownStatus{Sprint: sprintStatus.Name, Version: sprintStatus.Version}
+	b.RWMutex.RLock()
+	status.Restore, status.Paused = b.restore, b.scanPaused
+	b.RWMutex.RUnlock()
+	if b.Archiver != nil {
+		archive := b.Archiver.State()
+		status.Archive = &archive
+	}
+	if b.Pages != nil && ReadConfig().Pages.Enabled {
+		pages := b.Pages.State()
+		status.Pages = &pages
+	}
+	return status
+}
+
 func (b *Burndown) compressTimeline() map[string]TrelloState {
 	m := map[string]TrelloState{}
 	for _, event := range b.TrelloEvents {
@@ -303,13 +438,21 @@ func (b *Burndown) compressTimeline() map[string]TrelloState {
 	return m
 }
 
+// archivePath is the path of the sprint file in the archive, see ArchivePath.
+func (b *Burndown) archivePath() string {
+	return ArchivePath(ReadConfig().Archive, b.Sprint)
+}
+
+// save writes the sprint file and queues it for the archiver, a run of the archiver does not overlap it.
 func (b *Burndown) save() error {
-	err := os.MkdirAll("data", os.ModePerm)
+	lock := dirLock(ARCHIVE_DIR)
+	lock.Lock()
+	defer lock.Unlock()
+	filename := filepath.Join(ARCHIVE_DIR, filepath.FromSlash(b.archivePath()))
+	err := os.MkdirAll(filepath.Dir(filename), os.ModePerm)
 	if err != nil {
 		return err
 	}
-	startDate := b.Sprint.Start
-	filename := fmt.Sprintf("data/%d-%02d-%02d-%s-logs.json", startDate.Year(), startDate.Month(), startDate.Day(), b.Sprint.Name)
 
 	f, err := os.Create(filename)
 	if err != nil {
@@ -325,50 +468,45 @@ func (b *Burndown) save() error {
 	if err != nil {
 		return err
 	}
-	w.Flush()
-	return nil
-}
-func (b *Burndown) commitAndPush() error {
-	startDate := b.Sprint.Start
-	filename := fmt.Sprintf("%d-%02d-%02d-%s-logs.json", startDate.Year(), startDate.Month(), startDate.Day(), b.Sprint.Name)
-	token, err := ReadGithubToken()
-	if err != nil {
-		return err
-	}
-	git := NewGitRepository("data", "https://github.com/barakb/imc-sprints.git", token.AccessToken)
-	git.path = "/usr/local/git/bin/"
-	err = git.Init()
-	if err != nil {
+	if err := w.Flush(); err != nil {
 		return err
 	}
-
-	err = git.Add(filename)
-	if err != nil {
-		return err
-	}
-
-	err = git.Commit(fmt.Sprintf("Automatic update of file %s on %v", filename, time.Now()))
-	if err != nil {
-		return err
-	}
-
-	err = git.Rebase()
-	if err != nil {
-		return err
+	if b.Archiver != nil {
+		// before the lock is released, a run would reset the file if it was not committed
+		b.Archiver.Add(b.archivePath())
 	}
+	return nil
+}
 
-	err = git.Push()
-	if err != nil {
-		return err
+// commitAndPush archives the sprint file now, through the background archiver when there is one
+// so the git operations do not overlap.
+func (b *Burndown) commitAndPush() error {
+	archiver := b.Archiver
+	if archiver == nil {
+		archiver = newArchiver(ReadConfig().Archive)
 	}
-	return nil
+	archiver.Add(b.archivePath())
+	archiver.requestPush()
+	return archiver.Flush()
 }
 
 func (b *Burndown) load() (err error) {
-	startDate := b.Sprint.Start
-
-	filename := fmt.Sprintf("data/%d-%02d-%02d-%s-logs.json", startDate.Year(), startDate.Month(), startDate.Day(), b.Sprint.Name)
+	lock := dirLock(ARCHIVE_DIR)
+	lock.Lock()
+	defer lock.Unlock()
+	filename := filepath.Join(ARCHIVE_DIR, filepath.FromSlash(b.archivePath()))
 	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		// saved before the archive layout was configured
+		startDate := b.Sprint.Start
+		f, err = os.Open(fmt.Sprintf("data/%d-%02d-%02d-%s-logs.json", startDate.Year(), startDate.Month(), startDate.Day(), b.Sprint.Name))
+	}
+	if os.IsNotExist(err) {
+		// archived under another layout
+		if history, historyErr := ReadHistory(); historyErr == nil && history.Sprints[b.Sprint.Name] != nil {
+			f, err = os.Open(filepath.Join(ARCHIVE_DIR, filepath.FromSlash(history.Sprints[b.Sprint.Name].File)))
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -385,30 +523,60 @@ func (b *Burndown) load() (err error) {
 	return nil
 }
 
-func (b *Burndown) StartNewSprint(name string, start, end time.Time) chan struct{} {
-	res := make(chan struct{})
-	go func() {
-		b.commands <- func(b *Burndown) {
-			b.startNewSprint(name, start, end)
-			go func() {
-				res <- struct{}{}
-			}()
-		}
-	}()
-	return res
+// Exec runs cmd on the scan loop goroutine and waits for its result.
+func (b *Burndown) Exec(cmd func(b *Burndown) error) error {
+	res := make(chan error, 1)
+	b.commands <- func(b *Burndown) {
+		res <- cmd(b)
+	}
+	return <-res
 }
 
-func (b *Burndown) startNewSprint(name string, start, end time.Time) error {
+// archive saves the sprint data and pushes it to the sprints repository.
+func (b *Burndown) archive() error {
 	err := b.save()
 	if err != nil {
-		fmt.Printf("Got error %s while trying to sage changes\n", err.Error())
+		return err
 	}
-	err = b.commitAndPush()
+	return b.commitAndPush()
+}
+
+func (b *Burndown) setScanPaused(paused bool) {
+	b.RWMutex.Lock()
+	defer b.RWMutex.Unlock()
+	b.scanPaused = paused
+}
+
+// follows tells if the burndown is the one of sprint.
+func (b *Burndown) follows(sprint *Sprint) bool {
+	return b.Sprint != nil && sprint != nil && b.Sprint.Name == sprint.Name
+}
+
+// restorePause keeps the scan paused when the server stopped in the middle of a rollover.
+func (b *Burndown) restorePause() {
+	rollover, err := LastRollover()
 	if err != nil {
-		fmt.Printf("Got error %s while trying to commit changes\n", err.Error())
+		log.Printf("Error %q, while reading the last rollover\n", err.Error())
+		return
+	}
+	if rollover != nil && rollover.BurndownPaused && !rollover.Done() {
+		log.Printf("The burndown stays paused until the rollover to sprint %s is resumed\n", rollover.Name)
+		b.setScanPaused(true)
 	}
+}
 
-	board, err := b.Trello.Board("XAP Scrum")
+// resetSprint starts a fresh timeline for sprint.
+func (b *Burndown) resetSprint(sprint *Sprint) {
+	b.setScanPaused(false)
+	b.Sprint = sprint
+	b.SprintStatus = SprintStatus{}
+	b.TrelloEvents = []TrelloState{}
+	b.Version = 0
+}
+
+// closeDoneList closes the Done list of the ending sprint, it is a no-op once the list of the new sprint exists.
+func closeDoneList(xapTrello *Trello, name string) error {
+	board, err := xapTrello.Board("XAP Scrum")
 	if err != nil {
 		return err
 	}
@@ -416,26 +584,30 @@ func (b *Burndown) startNewSprint(name string, start, end time.Time) error {
 	if err != nil {
 		return err
 	}
-	for index, l := range lists {
-		if index == 0 {
-			err := l.Close()
-			if err != nil {
-				return err
-			}
-			break
-		}
+	if len(lists) == 0 || lists[0].Name == doneListName(name) {
+		return nil
 	}
+	return lists[0].Close()
+}
 
-	err = board.AddList(fmt.Sprintf("Done in %s", name), 0)
+// openDoneList adds the empty Done list of the new sprint as the first list of the board.
+func openDoneList(xapTrello *Trello, name string) error {
+	board, err := xapTrello.Board("XAP Scrum")
 	if err != nil {
 		return err
 	}
+	lists, err := board.Lists()
+	if err != nil {
+		return err
+	}
+	if 0 < len(lists) && lists[0].Name == doneListName(name) {
+		return nil
+	}
+	return board.AddList(doneListName(name), 0)
+}
 
-	b.Sprint = ReadSprint()
-	b.SprintStatus = SprintStatus{}
-	b.TrelloEvents = []TrelloState{}
-	b.Version = 0
-	return nil
+func doneListName(sprintName string) string {
+	return fmt.Sprintf("Done in %s", sprintName)
 }
 
 func sumPoints(lst trello.List) (p int) {
@@ -446,6 +618,20 @@ func sumPoints(lst trello.List) (p int) {
 	return p
 }
 
+// sumCodeCompletePoints splits the points of the Done list into the cards whose pull requests are merged
+// and the ones still in progress.
+func sumCodeCompletePoints(lst trello.List, pulls *PullRequestRegistry) (done int, inProgress int) {
+	cards, _ := lst.Cards()
+	for _, card := range cards {
+		if complete, _ := pulls.CodeComplete(card.Id); complete {
+			done += points(card.Name)
+		} else {
+			inProgress += points(card.Name)
+		}
+	}
+	return done, inProgress
+}
+
 func toDayStr(time time.Time) string {
 	const layout = "Mon, Jan 2"
 	return time.Format(layout)
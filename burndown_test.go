@@ -0,0 +1,42 @@
+package xap_trello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanOnceSumsThePointsOfTheLists(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Burndown{Trello: xapTrello, BurnDownData: BurnDownData{Sprint: &Sprint{Name: "12.1-M7",
+		Start: time.Date(2016, 11, 27, 0, 0, 0, 0, time.UTC), End: time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)}}}
+	state, err := b.scanOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (5) done, {M} and (3) in progress, (8) and (2) planned
+	if state.Done != 5 || state.InProgress != 28 || state.Planned != 10 {
+		t.Errorf("scanned %+v, expected 5 done, 28 in progress and 10 planned", state)
+	}
+}
+
+func TestPointsOfACardName(t *testing.T) {
+	cases := map[string]int{
+		"(5) xap-bug space fails":    5,
+		"{S} small one":              5,
+		"{med} medium one":           25,
+		"{L} xap-feature large":      100,
+		"no estimate":                0,
+		"(13) {L} points come first": 13,
+	}
+	for name, expected := range cases {
+		if got := points(name); got != expected {
+			t.Errorf("points(%q) = %d, expected %d", name, got, expected)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	xap_trello "github.com/barakb/xap-trello"
 	"gopkg.in/tylerb/graceful.v1"
@@ -9,20 +10,14 @@ import (
 )
 
 func main() {
-	//xapOpenJira, err := xap_trello.CreateXAPJiraOpen()
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-	//
-	//xapTrello, err := xap_trello.CreateXAPTrello()
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-	//
-	//var burndown = xap_trello.Burndown{Trello:xapTrello, Sprint:xapOpenJira.ActiveSprint}
-	//log.Println("calling ScanLoop")
-	//burndown.ScanLoop(2 * time.Second)
-	//log.Println("Done")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	sprintSourcePtr := flag.String("sprint-source", "", "Where the active sprint is read from, file, jira or static, default from "+xap_trello.CONFIG_FILE_NAME)
+	flag.Parse()
+	xap_trello.SelectSprintSource(*sprintSourcePtr)
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
 
 	xap_trello.InitRouters()
 	router := xap_trello.NewRouter()
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	jsonPtr := flag.Bool("json", false, "Print the explanation as json")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: explain [flags] <card id | card short link | card url>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	res, err := xap_trello.ExplainCard(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonPtr {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(res); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Printf("Card %q in list %q\n", res.CardName, res.List)
+	for _, match := range res.Matches {
+		if match.Matched {
+			fmt.Printf("  %-20s matched\n", match.Rule)
+		} else {
+			fmt.Printf("  %-20s %s\n", match.Rule, match.Reason)
+		}
+	}
+	switch {
+	case res.IssueType != "":
+		fmt.Printf("Rule %s creates a %s", res.Rule, res.IssueType)
+		if len(res.Fields) != 0 {
+			fields, _ := json.Marshal(res.Fields)
+			fmt.Printf(" with %s", fields)
+		}
+		fmt.Println()
+	case res.Rule != "":
+		fmt.Printf("Rule %s keeps the card without an issue\n", res.Rule)
+	case res.AttachTo != "":
+		fmt.Printf("No rule matched, the card is linked to %s\n", res.AttachTo)
+	default:
+		fmt.Println("No rule matched, the card is not synced")
+	}
+}
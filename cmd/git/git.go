@@ -1,41 +1,40 @@
 package main
 
 import (
+	"flag"
 	"github.com/barakb/xap-trello"
 	"log"
 )
 
 func main() {
-	token, err := xap_trello.ReadGithubToken()
+	localPtr := flag.String("local", "data", "The local clone of the archive")
+	config := xap_trello.ReadConfig().Archive
+	remotePtr := flag.String("remote", config.Remote, "The archive repository, archive.remote of "+xap_trello.CONFIG_FILE_NAME+" by default")
+	branchPtr := flag.String("branch", config.Branch, "The archive branch, archive.branch of "+xap_trello.CONFIG_FILE_NAME+" by default")
+	gitBackendPtr := flag.String("git-backend", config.Backend, "The git backend, exec or go-git")
+	flag.Parse()
+
+	config.Remote = *remotePtr
+	config.Branch = *branchPtr
+	config.Backend = *gitBackendPtr
+	git, err := xap_trello.NewArchiveRepository(config, *localPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := git.Clone(); err != nil {
+		log.Fatal(err)
+	}
 
-	git := xap_trello.NewGitRepository("/home/barakbo/tmp/t/imc-sprints", "https://github.com/barakb/imc-sprints.git", token.AccessToken)
-	git.Init()
-	//err :=  git.Log()
-	//if err != nil{
-	//	log.Println(err)
-	//}
-	//time.Sleep(100 * time.Millisecond)
-	//err := git.Add("-u")
-	//if err != nil{
-	//	log.Println(err)
-	//}
-	//err = git.Commit(fmt.Sprintf("Automatic update at %v", time.Now()))
-	//if err != nil{
-	//	log.Println(err)
-	//}
-	//
 	err = git.Rebase()
-	if err != nil{
-		log.Println(err)
+	if xap_trello.IsGitConflict(err) {
+		log.Fatalf("resolve the conflict in %s and run again: %s", *localPtr, err.Error())
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	err = git.Push()
-	if err != nil{
-		log.Println(err)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-
 }
-
-
-
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	matchPtr := flag.Bool("match", false, "Match the board members to Jira and GitHub users by email and save them in "+xap_trello.IDENTITIES_FILE_NAME)
+	jsonPtr := flag.Bool("json", false, "Print the identities as json")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	flag.Parse()
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	var directory *xap_trello.IdentityDirectory
+	var err error
+	if *matchPtr {
+		directory, err = xap_trello.MatchIdentities()
+	} else {
+		directory, err = xap_trello.LoadIdentities()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonPtr {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(directory.Identities); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, identity := range directory.Identities {
+		jira := identity.JiraAccountId
+		if jira == "" {
+			jira = identity.JiraName
+		}
+		fmt.Printf("%-20s trello %-16s jira %-28s github %-16s %s\n", identity.Name, identity.TrelloUsername, jira, identity.GitHubLogin, identity.Email)
+	}
+}
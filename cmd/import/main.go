@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	jqlPtr := flag.String("jql", "", "The Jira issues to import, defaults to import.jql of "+xap_trello.CONFIG_FILE_NAME)
+	formatPtr := flag.String("format", "text", "The output format, text or json")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	flag.Parse()
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := xap_trello.ImportIssues(*jqlPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *formatPtr {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	case "text":
+		report.WriteText(os.Stdout)
+	default:
+		log.Fatalf("unknown format %q", *formatPtr)
+	}
+}
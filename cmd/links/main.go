@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+	"time"
+)
+
+const DATE_TIME = "2006-01-02 15:04"
+
+func main() {
+	registryPtr := flag.String("registry", xap_trello.LINKS_FILE_NAME, "The link registry file")
+	jsonPtr := flag.Bool("json", false, "Print the links as json")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: links [flags] [card id | card url | issue key]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	links, err := xap_trello.OpenLinkRegistry(*registryPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res := links.All()
+	if id := flag.Arg(0); id != "" {
+		link, ok := links.Find(id)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "no link for %q\n", id)
+			os.Exit(1)
+		}
+		res = []xap_trello.Link{link}
+	}
+
+	if *jsonPtr {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(res); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, link := range res {
+		fmt.Printf("%-12s %-26s %-8s %-16s %s %q\n", link.IssueKey, link.CardId, link.SyncState, formatTime(link.LastSync), link.CardUrl, link.CardName)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(DATE_TIME)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+)
+
+func main() {
+	config := xap_trello.ReadConfig()
+	dirPtr := flag.String("dir", "", "Only export the site into this directory, nothing is published")
+	titlePtr := flag.String("title", config.Pages.Title, "The title of the site")
+	branchPtr := flag.String("branch", config.Pages.Branch, "The pages branch of the archive repository, gh-pages by default")
+	gitBackendPtr := flag.String("git-backend", config.Archive.Backend, "The git backend, exec or go-git")
+	flag.Parse()
+
+	if *dirPtr != "" {
+		files, err := xap_trello.ExportPages(*dirPtr, *titlePtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d file(s) written to %s\n", len(files), *dirPtr)
+		return
+	}
+	config.Pages.Title, config.Pages.Branch, config.Pages.Schedule = *titlePtr, *branchPtr, ""
+	config.Archive.Backend = *gitBackendPtr
+	pages, err := xap_trello.NewPagesPublisher(config.Pages, config.Archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := pages.Publish(); err != nil {
+		log.Fatal(err)
+	}
+}
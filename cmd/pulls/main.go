@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	formatPtr := flag.String("format", "text", "The output format, text or json")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pulls [flags] [card id | short link]\n")
+		fmt.Fprintf(os.Stderr, "links the pull requests of github.repositories, or prints the pull requests of a card\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	var res interface{}
+	if id := flag.Arg(0); id != "" {
+		registry, err := xap_trello.ReadPullRequestLinks()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pulls := registry.ByCard(id)
+		if *formatPtr == "text" {
+			for _, pull := range pulls {
+				fmt.Printf("%-30s %-7s %s %q\n", pull.Name(), pull.State, pull.Url, pull.Title)
+			}
+			return
+		}
+		res = pulls
+	} else {
+		report, err := xap_trello.LinkPullRequests()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *formatPtr == "text" {
+			report.WriteText(os.Stdout)
+			return
+		}
+		res = report
+	}
+	if *formatPtr != "json" {
+		log.Fatalf("unknown format %q", *formatPtr)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(res); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	listsPtr := flag.Int("lists", 3, "The nuber of lists (start counting from the left) in the 'XAP Scrum' board that are synced to Jira")
+	sprintPtr := flag.Int("sprint", -1, "The Jira sprint id, defaults to the active sprint")
+	formatPtr := flag.String("format", "text", "The output format, text or json")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	sprintSourcePtr := flag.String("sprint-source", "", "Where the active sprint is read from, file, jira or static, default from "+xap_trello.CONFIG_FILE_NAME)
+	flag.Parse()
+	xap_trello.SelectSprintSource(*sprintSourcePtr)
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := xap_trello.Reconcile(*listsPtr, *sprintPtr, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *formatPtr {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	case "text":
+		report.WriteText(os.Stdout)
+	default:
+		log.Fatalf("unknown format %q", *formatPtr)
+	}
+}
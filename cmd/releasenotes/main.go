@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"log"
+	"os"
+)
+
+func main() {
+	formatPtr := flag.String("format", "markdown", "The output format, markdown or json")
+	sprintPtr := flag.String("sprint", "", "The sprint, named after the Done list when empty")
+	publishPtr := flag.Bool("publish", false, "Publish the notes as release_notes of xap-trello.json configures")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: releasenotes [flags]\n")
+		fmt.Fprintf(os.Stderr, "prints the release notes of the Done list, grouped by bug, feature and label\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
+
+	xapTrello, err := xap_trello.CreateXAPTrello()
+	if err != nil {
+		log.Fatal(err)
+	}
+	notes, err := xap_trello.CollectReleaseNotes(xapTrello, *sprintPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *publishPtr {
+		location, err := xap_trello.PublishReleaseNotes(notes, xap_trello.ReadConfig().ReleaseNotes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Release notes of sprint %s published to %s\n", notes.Sprint, location)
+	}
+	switch *formatPtr {
+	case "markdown":
+		notes.WriteMarkdown(os.Stdout)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(notes); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown format %q", *formatPtr)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"github.com/barakb/xap-trello"
+	"flag"
 	"fmt"
+	"log"
+	"os"
 	"time"
 	"regexp"
 	"strconv"
@@ -11,88 +14,76 @@ import (
 const DATE = "2006-01-02"
 
 func main() {
-	//err := run()
-	//if err != nil {
-	//	fmt.Printf("Got error: %q, %#v\n", err.Error(), err)
-	//} else {
-	//	fmt.Println("All done")
-	//}
-	start, end, name, err := getNextSprintDefaults()
-	fmt.Printf("start %s, end %s, name %q, err: %v", start.Format(DATE), end.Format(DATE), name, err)
-}
+	namePtr := flag.String("name", "", "The name of the new sprint, defaults to the rollover that did not complete or the next name after the last Jira sprint")
+	startPtr := flag.String("start", "", "The start date of the new sprint (yyyy-mm-dd)")
+	endPtr := flag.String("end", "", "The end date of the new sprint (yyyy-mm-dd)")
+	dryPtr := flag.Bool("dry", false, "Only print the parameters of the new sprint")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
+	sprintSourcePtr := flag.String("sprint-source", "", "Where the active sprint is read from, file, jira or static, default from "+xap_trello.CONFIG_FILE_NAME)
+	flag.Parse()
+	xap_trello.SelectSprintSource(*sprintSourcePtr)
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
 
-func run() error {
 	start, end, name, err := getNextSprintDefaults()
-	if err != nil{
-		return err
-	}
-	xapOpenJira, err := xap_trello.CreateXAPJiraOpen()
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	if xapOpenJira.ActiveSprint.Name != "" {
-		fmt.Printf("Closing old sprint %s\n", xapOpenJira.ActiveSprint.Name)
-		_, _, err = xapOpenJira.Client.Board.CloseSprint(fmt.Sprintf("%d", xapOpenJira.ActiveSprint.ID))
-		if err != nil {
-			return err
-		}
+	if *namePtr != "" {
+		name = *namePtr
 	}
-
-	//start, err := time.Parse(DATE, "2016-12-04")
-	//if err != nil {
-	//	return err
-	//}
-	//end, err := time.Parse(DATE, "2016-12-08")
-	//if err != nil {
-	//	return err
-	//}
-
-	fmt.Printf("Creating a new sprint %s\n", name)
-	sprint, _, err := xapOpenJira.Client.Board.CreateSprint(name, start, end, xapOpenJira.MainScrumBoardId)
-	if err != nil {
-		return err
+	if *startPtr != "" {
+		if start, err = time.Parse(DATE, *startPtr); err != nil {
+			log.Fatal(err)
+		}
 	}
-	fmt.Printf("Moving items from trello to sprint %s\n", sprint.Name)
-	err = xap_trello.Trello2Jira(3, sprint.ID)
-	if err != nil {
-		return err
+	if *endPtr != "" {
+		if end, err = time.Parse(DATE, *endPtr); err != nil {
+			log.Fatal(err)
+		}
 	}
-	fmt.Printf("Starting sprint %s\n", sprint.Name)
-	sprint, _, err = xapOpenJira.Client.Board.StartSprint(fmt.Sprintf("%d", sprint.ID))
-	if err != nil {
-		return err
+	fmt.Printf("start %s, end %s, name %q\n", start.Format(DATE), end.Format(DATE), name)
+	if *dryPtr {
+		return
 	}
-	return nil
 
-	xapTrello, err := xap_trello.CreateXAPTrello()
-	if err != nil {
-		return err
+	report, err := xap_trello.NewSprintRollover(nil).Run(name, start, end)
+	if report != nil {
+		for _, step := range report.Steps {
+			fmt.Printf("%-20s %-8s %s\n", step.Name, step.Status, step.Error)
+		}
 	}
-	board, err := xapTrello.Board("Personal")
-	if err != nil {
-		return err
+	if err == xap_trello.ErrRolloverDone {
+		fmt.Printf("Sprint %s was already rolled over\n", name)
+		return
 	}
-	lists, err := board.Lists()
 	if err != nil {
-		return err
+		fmt.Printf("Got error: %q, run again to resume\n", err.Error())
+		os.Exit(1)
 	}
-	for index, l := range lists {
-		fmt.Printf("index %d, list:%s\n", index, l.Name)
-		//if index == 0 {
-		//	err := l.Close()
-		//	return err
-		//}
-	}
-	return board.AddList("New List", 0)
-
+	fmt.Println("All done")
 }
 
 func getNextSprintDefaults() (start, end time.Time, name string, err error) {
+	rollover, err := xap_trello.LastRollover()
+	if err != nil {
+		return
+	}
+	// a rollover that failed after creating the Jira sprint is resumed, not followed by the next one
+	if rollover != nil && !rollover.Done() {
+		fmt.Printf("Resuming the rollover to sprint %s\n", rollover.Name)
+		return rollover.Start, rollover.End, rollover.Name, nil
+	}
 	xapOpenJira, err := xap_trello.CreateXAPJiraOpen()
 	if err != nil {
 		return
 	}
 	sprint, _, err := xapOpenJira.Client.Board.GetLastSprint(fmt.Sprintf("%d", xapOpenJira.MainScrumBoardId))
+	if err != nil {
+		return
+	}
 	start = sprint.StartDate.AddDate(0, 0, 7)
 	end = sprint.EndDate.AddDate(0, 0, 7)
 	name, err = suggestNextSprintName(sprint.Name)
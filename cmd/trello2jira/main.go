@@ -3,11 +3,17 @@ package main
 import (
 	"github.com/barakb/xap-trello"
 	"flag"
+	"log"
 )
 
 func main() {
 	listsPtr := flag.Int("lists", 3, "The nuber of lists (start counting from the left) in the 'XAP Scrum' board to process")
+	backendPtr := flag.String("backend", xap_trello.BackendLive, "The Trello and Jira backend, live or fake")
+	fixturesPtr := flag.String("fixtures", "fake/fixtures", "The fixtures directory of the fake backend")
 	flag.Parse()
+	if err := xap_trello.SelectBackend(*backendPtr, *fixturesPtr); err != nil {
+		log.Fatal(err)
+	}
 	xap_trello.Trello2Jira(*listsPtr, -1)
 
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/barakb/xap-trello"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Sends a GitHub webhook payload signed the way GitHub signs it, to try the webhook of a local server.
+func main() {
+	urlPtr := flag.String("url", "http://localhost:6060/api/webhooks/github", "The webhook url")
+	eventPtr := flag.String("event", "push", "The X-GitHub-Event of the payload, like push or pull_request")
+	secretPtr := flag.String("secret", xap_trello.ReadConfig().GitHub.WebhookSecret, "The secret reference the payload is signed with, github.webhook_secret of "+xap_trello.CONFIG_FILE_NAME+" by default")
+	signPtr := flag.Bool("sign", false, "Only print the X-Hub-Signature-256 of the payload")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: webhook [flags] payload.json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	payload, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret, err := xap_trello.ResolveSecret(*secretPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signature := xap_trello.SignGitHubPayload(secret, payload)
+	if *signPtr {
+		fmt.Println(signature)
+		return
+	}
+
+	delivery := make([]byte, 16)
+	rand.Read(delivery)
+	req, err := http.NewRequest("POST", *urlPtr, bytes.NewReader(payload))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", *eventPtr)
+	req.Header.Set("X-GitHub-Delivery", hex.EncodeToString(delivery))
+	req.Header.Set("X-Hub-Signature-256", signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Printf("%s\n%s", resp.Status, body)
+	if resp.StatusCode != http.StatusAccepted {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package xap_trello
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+const CONFIG_FILE_NAME = "xap-trello.json"
+
+// LabelMapping is the Jira side of a Trello label, a card carrying the label gets these fields.
+type LabelMapping struct {
+	EpicLink   string   `json:"epic_link"`
+	Components []string `json:"components"`
+	Labels     []string `json:"labels"`
+}
+
+type JiraConfig struct {
+	Url  string         `json:"url"`
+	Auth JiraAuthConfig `json:"auth"`
+}
+
+type JiraWebhookConfig struct {
+	// a secret reference, see ResolveSecret
+	Secret string `json:"secret"`
+	// how long an issue has to be quiet before it is synced, like "10s"
+	Delay string `json:"delay"`
+}
+
+type Config struct {
+	Jira        JiraConfig        `json:"jira"`
+	JiraWebhook JiraWebhookConfig `json:"jira_webhook"`
+	Sprint      SprintConfig      `json:"sprint"`
+	Import      ImportConfig      `json:"import"`
+	// the git repository the sprint data is archived in
+	Archive ArchiveConfig `json:"archive"`
+	// the static site of the archived sprints, on a branch of the archive repository
+	Pages PagesConfig `json:"pages"`
+	// pull requests linked to cards
+	GitHub GitHubConfig `json:"github"`
+	// attach the burndown and the cards of each sprint to Jira at rollover
+	SprintReport SprintReportConfig `json:"sprint_report"`
+	// the Done cards of each sprint as release notes, at rollover
+	ReleaseNotes ReleaseNotesConfig `json:"release_notes"`
+	// merged with identities.json, see IdentityDirectory
+	Identities []Identity `json:"identities"`
+	// keyed by the Trello label name
+	LabelMappings map[string]LabelMapping `json:"label_mappings"`
+}
+
+// ReadConfig reads xap-trello.json from the working directory, a missing file yields an empty config.
+func ReadConfig() *Config {
+	config := &Config{}
+	if err := FromJSONFile(config, CONFIG_FILE_NAME); err != nil && !os.IsNotExist(err) {
+		log.Printf("error while reading config from file %s: %s\n", CONFIG_FILE_NAME, err.Error())
+	}
+	if config.Jira.Url == "" {
+		config.Jira.Url = "https://insightedge.atlassian.net"
+	}
+	if config.LabelMappings == nil {
+		config.LabelMappings = map[string]LabelMapping{}
+	}
+	return config
+}
+
+// ResolveSecret keeps credentials out of the config file, env:NAME is replaced by the environment
+// variable NAME and file:/path by the trimmed content of the file (docker and kubernetes secrets),
+// any other value is used as is.
+func ResolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+	if strings.HasPrefix(value, "file:") {
+		bytes, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(bytes)), nil
+	}
+	return value, nil
+}
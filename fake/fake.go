@@ -0,0 +1,69 @@
+// Package fake serves in memory Trello and Jira REST APIs seeded from json fixtures, for demos and
+// offline development.
+package fake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+const TrelloHost = "api.trello.com"
+
+// Backend is a running pair of fake servers.
+type Backend struct {
+	Trello *Trello
+	Jira   *Jira
+}
+
+// Start loads trello.json and jira.json from the fixtures directory and starts both servers.
+func Start(fixtures string) (*Backend, error) {
+	trelloFixture := TrelloFixture{}
+	if err := readFixture(path.Join(fixtures, "trello.json"), &trelloFixture); err != nil {
+		return nil, err
+	}
+	jiraFixture := JiraFixture{}
+	if err := readFixture(path.Join(fixtures, "jira.json"), &jiraFixture); err != nil {
+		return nil, err
+	}
+	return &Backend{Trello: NewTrello(trelloFixture), Jira: NewJira(jiraFixture)}, nil
+}
+
+func (b *Backend) Close() {
+	b.Trello.Close()
+	b.Jira.Close()
+}
+
+// Transport sends requests for api.trello.com to the fake Trello server, the Trello client has no
+// way to change its endpoint.
+func (b *Backend) Transport(base http.RoundTripper) http.RoundTripper {
+	target, _ := url.Parse(b.Trello.URL)
+	return redirectTransport{base: base, host: TrelloHost, target: target}
+}
+
+type redirectTransport struct {
+	base   http.RoundTripper
+	host   string
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	clone := *req
+	u := *req.URL
+	u.Scheme, u.Host = t.target.Scheme, t.target.Host
+	clone.URL, clone.Host = &u, t.target.Host
+	return t.base.RoundTrip(&clone)
+}
+
+func readFixture(filename string, val interface{}) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, val)
+}
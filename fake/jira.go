@@ -0,0 +1,576 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JiraIssueType struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type JiraField struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Custom bool   `json:"custom"`
+}
+
+type JiraBoard struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type JiraSprint struct {
+	Id            int        `json:"id"`
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	OriginBoardId int        `json:"originBoardId"`
+	StartDate     *time.Time `json:"startDate,omitempty"`
+	EndDate       *time.Time `json:"endDate,omitempty"`
+	CompleteDate  *time.Time `json:"completeDate,omitempty"`
+}
+
+type JiraComment struct {
+	Id      string            `json:"id"`
+	Body    string            `json:"body"`
+	Author  map[string]string `json:"author"`
+	Created string            `json:"created"`
+}
+
+type JiraIssue struct {
+	Id     string                 `json:"id"`
+	Key    string                 `json:"key"`
+	Fields map[string]interface{} `json:"fields"`
+	// the sprint of the issue, 0 for the backlog
+	Sprint      int                      `json:"sprint"`
+	Comments    []JiraComment            `json:"-"`
+	RemoteLinks []map[string]interface{} `json:"-"`
+}
+
+type JiraUser struct {
+	AccountId    string `json:"accountId"`
+	Name         string `json:"name"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+// JiraFixture is the content of jira.json.
+type JiraFixture struct {
+	Project    string          `json:"project"`
+	IssueTypes []JiraIssueType `json:"issue_types"`
+	Fields     []JiraField     `json:"fields"`
+	Boards     []JiraBoard     `json:"boards"`
+	Sprints    []*JiraSprint   `json:"sprints"`
+	Issues     []*JiraIssue    `json:"issues"`
+	Users      []JiraUser      `json:"users"`
+}
+
+// Jira is an in memory implementation of the Jira REST and Agile endpoints used by go-jira and this project.
+type Jira struct {
+	sync.Mutex
+	*httptest.Server
+	fixture JiraFixture
+	nextId  int
+}
+
+func NewJira(fixture JiraFixture) *Jira {
+	j := &Jira{fixture: fixture, nextId: 10000}
+	for _, issue := range j.fixture.Issues {
+		if issue.Fields == nil {
+			issue.Fields = map[string]interface{}{}
+		}
+		if id, err := strconv.Atoi(issue.Id); err == nil && j.nextId <= id {
+			j.nextId = id + 1
+		}
+	}
+	for _, sprint := range j.fixture.Sprints {
+		if j.nextId <= sprint.Id {
+			j.nextId = sprint.Id + 1
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/rest/auth/1/session", j.session).Methods("POST", "GET")
+	agile := r.PathPrefix("/rest/agile/1.0").Subrouter()
+	agile.HandleFunc("/board", j.boards).Methods("GET")
+	agile.HandleFunc("/board/{id}/sprint", j.boardSprints).Methods("GET")
+	agile.HandleFunc("/sprint", j.createSprint).Methods("POST")
+	agile.HandleFunc("/sprint/{id}", j.sprint).Methods("GET")
+	agile.HandleFunc("/sprint/{id}", j.updateSprint).Methods("POST", "PUT")
+	agile.HandleFunc("/sprint/{id}/issue", j.moveToSprint).Methods("POST")
+	agile.HandleFunc("/backlog/issue", j.moveToBacklog).Methods("POST")
+	api := r.PathPrefix("/rest/api/2").Subrouter()
+	api.HandleFunc("/project/{key}", j.project).Methods("GET")
+	api.HandleFunc("/field", j.fields).Methods("GET")
+	api.HandleFunc("/user/search", j.userSearch).Methods("GET")
+	api.HandleFunc("/search", j.search).Methods("GET", "POST")
+	api.HandleFunc("/issue", j.createIssue).Methods("POST")
+	api.HandleFunc("/issue/{key}", j.issue).Methods("GET")
+	api.HandleFunc("/issue/{key}", j.updateIssue).Methods("PUT")
+	api.HandleFunc("/issue/{key}/editmeta", j.editMeta).Methods("GET")
+	api.HandleFunc("/issue/{key}/comment", j.comments).Methods("GET")
+	api.HandleFunc("/issue/{key}/comment", j.addComment).Methods("POST")
+	api.HandleFunc("/issue/{key}/remotelink", j.addRemoteLink).Methods("POST")
+	api.HandleFunc("/issue/{key}/attachments", j.addAttachment).Methods("POST")
+	j.Server = httptest.NewServer(r)
+	return j
+}
+
+func (j *Jira) id() int {
+	j.nextId++
+	return j.nextId
+}
+
+func (j *Jira) findIssue(key string) *JiraIssue {
+	for _, issue := range j.fixture.Issues {
+		if issue.Key == key || issue.Id == key {
+			return issue
+		}
+	}
+	return nil
+}
+
+func (j *Jira) findSprint(id string) *JiraSprint {
+	for _, sprint := range j.fixture.Sprints {
+		if strconv.Itoa(sprint.Id) == id {
+			return sprint
+		}
+	}
+	return nil
+}
+
+func (j *Jira) fieldId(name string) string {
+	for _, field := range j.fixture.Fields {
+		if field.Name == name {
+			return field.Id
+		}
+	}
+	return ""
+}
+
+func (j *Jira) session(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "fake", Path: "/"})
+	writeJSON(w, map[string]interface{}{"session": map[string]string{"name": "JSESSIONID", "value": "fake"}})
+}
+
+func (j *Jira) boards(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	values := []JiraBoard{}
+	for _, board := range j.fixture.Boards {
+		if t := r.FormValue("type"); t == "" || t == board.Type {
+			values = append(values, board)
+		}
+	}
+	writeJSON(w, map[string]interface{}{"maxResults": len(values), "total": len(values), "isLast": true, "values": values})
+}
+
+func (j *Jira) boardSprints(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	boardId, _ := strconv.Atoi(mux.Vars(r)["id"])
+	states := r.FormValue("state")
+	values := []JiraSprint{}
+	for _, sprint := range j.fixture.Sprints {
+		if sprint.OriginBoardId == boardId && (states == "" || strings.Contains(states, sprint.State)) {
+			values = append(values, *sprint)
+		}
+	}
+	writeJSON(w, map[string]interface{}{"maxResults": len(values), "isLast": true, "values": values})
+}
+
+func (j *Jira) createSprint(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	sprint := &JiraSprint{}
+	if err := json.NewDecoder(r.Body).Decode(sprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sprint.Id, sprint.State = j.id(), "future"
+	j.fixture.Sprints = append(j.fixture.Sprints, sprint)
+	writeJSONStatus(w, http.StatusCreated, sprint)
+}
+
+func (j *Jira) sprint(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	sprint := j.findSprint(mux.Vars(r)["id"])
+	if sprint == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, sprint)
+}
+
+func (j *Jira) updateSprint(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	sprint := j.findSprint(mux.Vars(r)["id"])
+	if sprint == nil {
+		http.NotFound(w, r)
+		return
+	}
+	update := JiraSprint{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if update.Name != "" {
+		sprint.Name = update.Name
+	}
+	if update.StartDate != nil {
+		sprint.StartDate = update.StartDate
+	}
+	if update.EndDate != nil {
+		sprint.EndDate = update.EndDate
+	}
+	if update.State != "" && update.State != sprint.State {
+		now := time.Now()
+		if update.State == "closed" {
+			sprint.CompleteDate = &now
+			// unresolved issues go back to the backlog
+			for _, issue := range j.fixture.Issues {
+				if issue.Sprint == sprint.Id && statusCategory(issue) != "done" {
+					issue.Sprint = 0
+				}
+			}
+		}
+		sprint.State = update.State
+	}
+	writeJSON(w, sprint)
+}
+
+func (j *Jira) moveToSprint(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	sprint := j.findSprint(mux.Vars(r)["id"])
+	if sprint == nil {
+		http.NotFound(w, r)
+		return
+	}
+	j.moveIssues(w, r, sprint.Id)
+}
+
+func (j *Jira) moveToBacklog(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	j.moveIssues(w, r, 0)
+}
+
+func (j *Jira) moveIssues(w http.ResponseWriter, r *http.Request, sprintId int) {
+	body := struct {
+		Issues []string `json:"issues"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, key := range body.Issues {
+		issue := j.findIssue(key)
+		if issue == nil {
+			http.Error(w, fmt.Sprintf("Issue does not exist: %s", key), http.StatusBadRequest)
+			return
+		}
+		issue.Sprint = sprintId
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (j *Jira) project(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	key := mux.Vars(r)["key"]
+	if key != j.fixture.Project {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"id": "10000", "key": key, "name": key, "issueTypes": j.fixture.IssueTypes})
+}
+
+func (j *Jira) fields(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	writeJSON(w, j.fixture.Fields)
+}
+
+// userSearch matches the start of the name, display name or email as Jira does.
+func (j *Jira) userSearch(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	query := strings.ToLower(r.FormValue("query"))
+	if query == "" {
+		query = strings.ToLower(r.FormValue("username"))
+	}
+	res := []JiraUser{}
+	for _, user := range j.fixture.Users {
+		for _, value := range []string{user.Name, user.DisplayName, user.EmailAddress} {
+			if query != "" && strings.HasPrefix(strings.ToLower(value), query) {
+				res = append(res, user)
+				break
+			}
+		}
+	}
+	writeJSON(w, res)
+}
+
+var (
+	sprintClause   = regexp.MustCompile(`(?i)sprint\s*=\s*(\d+)`)
+	containsClause = regexp.MustCompile(`"([^"]+)"\s*~\s*"([^"]*)"`)
+	keyClause      = regexp.MustCompile(`(?i)key\s*=\s*"?([A-Z]+-\d+)"?`)
+)
+
+// search understands Sprint=<id>, key=<key> and "<field name>" ~ "<text>" clauses joined by AND,
+// any other query matches every issue.
+func (j *Jira) search(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	jql := r.FormValue("jql")
+	if r.Method == "POST" {
+		body := struct {
+			Jql string `json:"jql"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		jql = body.Jql
+	}
+	issues := []JiraIssue{}
+	for _, issue := range j.fixture.Issues {
+		if j.matches(issue, jql) {
+			issues = append(issues, j.withSprint(issue))
+		}
+	}
+	startAt, _ := strconv.Atoi(r.FormValue("startAt"))
+	if len(issues) < startAt {
+		startAt = len(issues)
+	}
+	writeJSON(w, map[string]interface{}{"startAt": startAt, "maxResults": len(issues), "total": len(issues), "issues": issues[startAt:]})
+}
+
+func (j *Jira) matches(issue *JiraIssue, jql string) bool {
+	if found := sprintClause.FindStringSubmatch(jql); found != nil && strconv.Itoa(issue.Sprint) != found[1] {
+		return false
+	}
+	if found := keyClause.FindStringSubmatch(jql); found != nil && issue.Key != found[1] {
+		return false
+	}
+	for _, found := range containsClause.FindAllStringSubmatch(jql, -1) {
+		value, _ := issue.Fields[j.fieldId(found[1])].(string)
+		if found[1] == "summary" {
+			value, _ = issue.Fields["summary"].(string)
+		}
+		if !strings.Contains(strings.ToLower(value), strings.ToLower(found[2])) {
+			return false
+		}
+	}
+	return true
+}
+
+// withSprint returns a copy of the issue with the sprint custom field filled in.
+func (j *Jira) withSprint(issue *JiraIssue) JiraIssue {
+	res := *issue
+	res.Fields = map[string]interface{}{}
+	for k, v := range issue.Fields {
+		res.Fields[k] = v
+	}
+	if sprintField := j.fieldId("Sprint"); sprintField != "" && issue.Sprint != 0 {
+		if sprint := j.findSprint(strconv.Itoa(issue.Sprint)); sprint != nil {
+			res.Fields[sprintField] = []JiraSprint{*sprint}
+		}
+	}
+	return res
+}
+
+func statusCategory(issue *JiraIssue) string {
+	status, _ := issue.Fields["status"].(map[string]interface{})
+	category, _ := status["statusCategory"].(map[string]interface{})
+	key, _ := category["key"].(string)
+	return key
+}
+
+func (j *Jira) createIssue(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	body := struct {
+		Fields map[string]interface{} `json:"fields"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := j.id()
+	issue := &JiraIssue{Id: strconv.Itoa(id), Key: fmt.Sprintf("%s-%d", j.fixture.Project, id), Fields: body.Fields}
+	issue.Fields["status"] = map[string]interface{}{"name": "Open", "statusCategory": map[string]interface{}{"key": "new"}}
+	issue.Fields["created"] = time.Now().Format("2006-01-02T15:04:05.000-0700")
+	j.fixture.Issues = append(j.fixture.Issues, issue)
+	writeJSONStatus(w, http.StatusCreated, map[string]string{"id": issue.Id, "key": issue.Key, "self": fmt.Sprintf("%s/rest/api/2/issue/%s", j.URL, issue.Id)})
+}
+
+func (j *Jira) issue(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.Error(w, `{"errorMessages":["Issue does not exist"]}`, http.StatusNotFound)
+		return
+	}
+	res := j.withSprint(issue)
+	res.Fields["comment"] = map[string]interface{}{"comments": issue.Comments, "total": len(issue.Comments)}
+	if _, ok := res.Fields["attachment"]; !ok {
+		res.Fields["attachment"] = []interface{}{}
+	}
+	writeJSON(w, res)
+}
+
+// updateIssue applies "fields" and the add, remove and set operations of "update".
+func (j *Jira) updateIssue(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.NotFound(w, r)
+		return
+	}
+	body := struct {
+		Fields map[string]interface{}              `json:"fields"`
+		Update map[string][]map[string]interface{} `json:"update"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for k, v := range body.Fields {
+		issue.Fields[k] = v
+	}
+	for field, operations := range body.Update {
+		values, _ := issue.Fields[field].([]interface{})
+		for _, operation := range operations {
+			if v, ok := operation["set"]; ok {
+				issue.Fields[field] = v
+				continue
+			}
+			if v, ok := operation["add"]; ok {
+				values = append(values, v)
+			}
+			if v, ok := operation["remove"]; ok {
+				kept := []interface{}{}
+				for _, value := range values {
+					if fmt.Sprint(value) != fmt.Sprint(v) {
+						kept = append(kept, value)
+					}
+				}
+				values = kept
+			}
+			issue.Fields[field] = values
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (j *Jira) editMeta(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	if j.findIssue(mux.Vars(r)["key"]) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	fields := map[string]interface{}{}
+	for _, field := range j.fixture.Fields {
+		fields[field.Id] = map[string]interface{}{"name": field.Name, "required": false}
+	}
+	writeJSON(w, map[string]interface{}{"fields": fields})
+}
+
+func (j *Jira) comments(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"comments": append([]JiraComment{}, issue.Comments...), "total": len(issue.Comments)})
+}
+
+func (j *Jira) addComment(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.NotFound(w, r)
+		return
+	}
+	comment := JiraComment{}
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	comment.Id = strconv.Itoa(j.id())
+	comment.Author = map[string]string{"name": "fake", "displayName": "Fake User"}
+	comment.Created = time.Now().Format("2006-01-02T15:04:05.000-0700")
+	issue.Comments = append(issue.Comments, comment)
+	writeJSONStatus(w, http.StatusCreated, comment)
+}
+
+// addAttachment keeps the name and size of the uploaded files, the content is dropped.
+func (j *Jira) addAttachment(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("X-Atlassian-Token") != "no-check" {
+		http.Error(w, "XSRF check failed", http.StatusForbidden)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	attachments, _ := issue.Fields["attachment"].([]interface{})
+	id := j.id()
+	attachment := map[string]interface{}{
+		"id":       strconv.Itoa(id),
+		"filename": header.Filename,
+		"content":  fmt.Sprintf("%s/secure/attachment/%d/%s", j.URL, id, header.Filename),
+	}
+	issue.Fields["attachment"] = append(attachments, attachment)
+	writeJSON(w, []interface{}{attachment})
+}
+
+func (j *Jira) addRemoteLink(w http.ResponseWriter, r *http.Request) {
+	j.Lock()
+	defer j.Unlock()
+	issue := j.findIssue(mux.Vars(r)["key"])
+	if issue == nil {
+		http.NotFound(w, r)
+		return
+	}
+	link := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for index, existing := range issue.RemoteLinks {
+		if existing["globalId"] != nil && existing["globalId"] == link["globalId"] {
+			issue.RemoteLinks[index] = link
+			writeJSON(w, map[string]interface{}{"id": index + 1})
+			return
+		}
+	}
+	issue.RemoteLinks = append(issue.RemoteLinks, link)
+	writeJSONStatus(w, http.StatusCreated, map[string]interface{}{"id": len(issue.RemoteLinks)})
+}
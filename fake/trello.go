@@ -0,0 +1,598 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TrelloMember struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	FullName string `json:"fullName"`
+	Email    string `json:"email,omitempty"`
+}
+
+type TrelloLabel struct {
+	Id    string `json:"id,omitempty"`
+	Color string `json:"color"`
+	Name  string `json:"name"`
+}
+
+type TrelloCard struct {
+	Id        string             `json:"id"`
+	Name      string             `json:"name"`
+	Desc      string             `json:"desc"`
+	IdList    string             `json:"idList"`
+	IdBoard   string             `json:"idBoard"`
+	IdMembers []string           `json:"idMembers"`
+	Labels    []TrelloLabel      `json:"labels"`
+	Url       string             `json:"url"`
+	ShortUrl  string             `json:"shortUrl"`
+	ShortLink string             `json:"shortLink"`
+	Closed    bool               `json:"closed"`
+	Pos       float64            `json:"pos"`
+	Comments  []TrelloAction     `json:"-"`
+	Files     []TrelloAttachment `json:"-"`
+	// only returned by the checklists route
+	Checklists []TrelloChecklist `json:"checklists,omitempty"`
+}
+
+type TrelloChecklist struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type TrelloList struct {
+	Id      string        `json:"id"`
+	Name    string        `json:"name"`
+	IdBoard string        `json:"idBoard"`
+	Closed  bool          `json:"closed"`
+	Pos     float64       `json:"pos"`
+	Cards   []*TrelloCard `json:"cards,omitempty"`
+}
+
+type TrelloBoard struct {
+	Id      string         `json:"id"`
+	Name    string         `json:"name"`
+	Url     string         `json:"url"`
+	Closed  bool           `json:"closed"`
+	Lists   []*TrelloList  `json:"lists,omitempty"`
+	Members []TrelloMember `json:"members,omitempty"`
+}
+
+type TrelloAction struct {
+	Id            string       `json:"id"`
+	Type          string       `json:"type"`
+	Date          time.Time    `json:"date"`
+	Data          TrelloData   `json:"data"`
+	MemberCreator TrelloMember `json:"memberCreator"`
+}
+
+type TrelloData struct {
+	Text string `json:"text"`
+}
+
+type TrelloAttachment struct {
+	Id   string    `json:"id"`
+	Name string    `json:"name"`
+	Url  string    `json:"url"`
+	Date time.Time `json:"date"`
+}
+
+// TrelloFixture is the content of trello.json, the board, its lists and their cards.
+type TrelloFixture struct {
+	Me     TrelloMember   `json:"me"`
+	Boards []*TrelloBoard `json:"boards"`
+}
+
+// Trello is an in memory implementation of the Trello REST endpoints used by go-trello and this project.
+type Trello struct {
+	sync.Mutex
+	*httptest.Server
+	me     TrelloMember
+	boards []*TrelloBoard
+	nextId int
+}
+
+func NewTrello(fixture TrelloFixture) *Trello {
+	t := &Trello{me: fixture.Me, boards: fixture.Boards, nextId: 1}
+	for _, board := range t.boards {
+		for index, list := range board.Lists {
+			list.IdBoard = board.Id
+			if list.Pos == 0 {
+				list.Pos = float64(index+1) * 1024
+			}
+			for cardIndex, card := range list.Cards {
+				card.IdList, card.IdBoard = list.Id, board.Id
+				if card.Pos == 0 {
+					card.Pos = float64(cardIndex+1) * 1024
+				}
+				if card.ShortLink == "" {
+					card.ShortLink = card.Id
+				}
+				if card.Url == "" {
+					card.Url = "https://trello.com/c/" + card.ShortLink
+				}
+				if card.ShortUrl == "" {
+					card.ShortUrl = card.Url
+				}
+				if card.IdMembers == nil {
+					card.IdMembers = []string{}
+				}
+				if card.Labels == nil {
+					card.Labels = []TrelloLabel{}
+				}
+			}
+		}
+	}
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/1").Subrouter()
+	api.HandleFunc("/members/{id}", t.member).Methods("GET")
+	api.HandleFunc("/members/{id}/boards", t.memberBoards).Methods("GET")
+	api.HandleFunc("/members/{id}/notifications", t.notifications).Methods("GET")
+	api.HandleFunc("/search", t.search).Methods("GET")
+	api.HandleFunc("/boards/{id}", t.board).Methods("GET")
+	api.HandleFunc("/boards/{id}/lists", t.boardLists).Methods("GET")
+	api.HandleFunc("/boards/{id}/lists", t.addList).Methods("POST")
+	api.HandleFunc("/boards/{id}/cards", t.boardCards).Methods("GET")
+	api.HandleFunc("/boards/{id}/members", t.boardMembers).Methods("GET")
+	api.HandleFunc("/lists", t.addList).Methods("POST")
+	api.HandleFunc("/lists/{id}", t.list).Methods("GET")
+	api.HandleFunc("/lists/{id}", t.updateList).Methods("PUT")
+	api.HandleFunc("/lists/{id}/closed", t.updateList).Methods("PUT")
+	api.HandleFunc("/lists/{id}/cards", t.listCards).Methods("GET")
+	api.HandleFunc("/lists/{id}/cards", t.addCard).Methods("POST")
+	api.HandleFunc("/cards", t.addCard).Methods("POST")
+	api.HandleFunc("/cards/{id}", t.card).Methods("GET")
+	api.HandleFunc("/cards/{id}", t.updateCard).Methods("PUT")
+	api.HandleFunc("/cards/{id}/{field}", t.updateCard).Methods("PUT")
+	api.HandleFunc("/cards/{id}/actions", t.cardActions).Methods("GET")
+	api.HandleFunc("/cards/{id}/actions/comments", t.addComment).Methods("POST")
+	api.HandleFunc("/cards/{id}/idMembers", t.addMember).Methods("POST")
+	api.HandleFunc("/cards/{id}/attachments", t.cardAttachments).Methods("GET")
+	api.HandleFunc("/cards/{id}/checklists", t.cardChecklists).Methods("GET")
+	api.HandleFunc("/cards/{id}/attachments", t.addAttachment).Methods("POST")
+	t.Server = httptest.NewServer(r)
+	return t
+}
+
+func (t *Trello) id() string {
+	t.nextId++
+	return fmt.Sprintf("%024x", t.nextId)
+}
+
+func (t *Trello) findBoard(id string) *TrelloBoard {
+	for _, board := range t.boards {
+		if board.Id == id {
+			return board
+		}
+	}
+	return nil
+}
+
+func (t *Trello) findList(id string) *TrelloList {
+	for _, board := range t.boards {
+		for _, list := range board.Lists {
+			if list.Id == id {
+				return list
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Trello) findCard(id string) *TrelloCard {
+	for _, board := range t.boards {
+		for _, list := range board.Lists {
+			for _, card := range list.Cards {
+				if card.Id == id || card.ShortLink == id {
+					return card
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Trello) openLists(board *TrelloBoard) []*TrelloList {
+	lists := []*TrelloList{}
+	for _, list := range board.Lists {
+		if !list.Closed {
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+func (t *Trello) member(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	id := mux.Vars(r)["id"]
+	if id == "me" || id == t.me.Id || id == t.me.Username {
+		writeJSON(w, t.me)
+		return
+	}
+	for _, board := range t.boards {
+		for _, member := range board.Members {
+			if id == member.Id || id == member.Username {
+				writeJSON(w, member)
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (t *Trello) memberBoards(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	writeJSON(w, t.boardsWithoutLists())
+}
+
+func (t *Trello) boardsWithoutLists() []TrelloBoard {
+	boards := []TrelloBoard{}
+	for _, board := range t.boards {
+		b := *board
+		b.Lists, b.Members = nil, nil
+		boards = append(boards, b)
+	}
+	return boards
+}
+
+func (t *Trello) notifications(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []interface{}{})
+}
+
+func (t *Trello) search(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	query := strings.ToLower(r.FormValue("query"))
+	cards := []TrelloCard{}
+	for _, board := range t.boards {
+		for _, list := range board.Lists {
+			for _, card := range list.Cards {
+				if strings.Contains(strings.ToLower(card.Name+" "+card.Desc), query) {
+					cards = append(cards, *card)
+				}
+			}
+		}
+	}
+	writeJSON(w, map[string]interface{}{"cards": cards})
+}
+
+func (t *Trello) board(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	board := t.findBoard(mux.Vars(r)["id"])
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+	b := *board
+	b.Lists, b.Members = nil, nil
+	writeJSON(w, b)
+}
+
+func (t *Trello) boardLists(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	board := t.findBoard(mux.Vars(r)["id"])
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+	lists := []TrelloList{}
+	for _, list := range t.openLists(board) {
+		l := *list
+		l.Cards = nil
+		lists = append(lists, l)
+	}
+	writeJSON(w, lists)
+}
+
+func (t *Trello) boardCards(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	board := t.findBoard(mux.Vars(r)["id"])
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+	cards := []TrelloCard{}
+	for _, list := range t.openLists(board) {
+		for _, card := range list.Cards {
+			cards = append(cards, *card)
+		}
+	}
+	writeJSON(w, cards)
+}
+
+func (t *Trello) boardMembers(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	board := t.findBoard(mux.Vars(r)["id"])
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+	members := append([]TrelloMember{}, board.Members...)
+	writeJSON(w, members)
+}
+
+func (t *Trello) addList(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	boardId := mux.Vars(r)["id"]
+	if boardId == "" {
+		boardId = r.FormValue("idBoard")
+	}
+	board := t.findBoard(boardId)
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+	list := &TrelloList{Id: t.id(), Name: r.FormValue("name"), IdBoard: board.Id}
+	lists := t.openLists(board)
+	switch pos := r.FormValue("pos"); {
+	case pos == "top" || pos == "0":
+		list.Pos = 1
+		if 0 < len(lists) {
+			list.Pos = lists[0].Pos / 2
+		}
+		board.Lists = append([]*TrelloList{list}, board.Lists...)
+	default:
+		list.Pos = 1024
+		if 0 < len(lists) {
+			list.Pos = lists[len(lists)-1].Pos + 1024
+		}
+		board.Lists = append(board.Lists, list)
+	}
+	writeJSON(w, list)
+}
+
+func (t *Trello) list(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	list := t.findList(mux.Vars(r)["id"])
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	res := *list
+	res.Cards = nil
+	writeJSON(w, res)
+}
+
+func (t *Trello) updateList(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	list := t.findList(mux.Vars(r)["id"])
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if name := r.FormValue("name"); name != "" {
+		list.Name = name
+	}
+	closed := r.FormValue("closed")
+	if strings.HasSuffix(r.URL.Path, "/closed") {
+		closed = r.FormValue("value")
+	}
+	if closed != "" {
+		list.Closed = closed == "true"
+	}
+	l := *list
+	l.Cards = nil
+	writeJSON(w, l)
+}
+
+func (t *Trello) listCards(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	list := t.findList(mux.Vars(r)["id"])
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	cards := []TrelloCard{}
+	for _, card := range list.Cards {
+		if !card.Closed {
+			cards = append(cards, *card)
+		}
+	}
+	writeJSON(w, cards)
+}
+
+func (t *Trello) addCard(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	listId := mux.Vars(r)["id"]
+	if listId == "" {
+		listId = r.FormValue("idList")
+	}
+	list := t.findList(listId)
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id := t.id()
+	card := &TrelloCard{Id: id, Name: r.FormValue("name"), Desc: r.FormValue("desc"), IdList: list.Id,
+		IdBoard: list.IdBoard, ShortLink: id[16:], IdMembers: []string{}, Labels: []TrelloLabel{}}
+	card.Url = "https://trello.com/c/" + card.ShortLink
+	card.ShortUrl = card.Url
+	if r.FormValue("pos") == "top" {
+		list.Cards = append([]*TrelloCard{card}, list.Cards...)
+	} else {
+		list.Cards = append(list.Cards, card)
+	}
+	writeJSON(w, card)
+}
+
+func (t *Trello) card(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, card)
+}
+
+// updateCard handles both PUT /cards/{id}?desc=... and PUT /cards/{id}/desc?value=...
+func (t *Trello) updateCard(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	values := map[string]string{}
+	if field := mux.Vars(r)["field"]; field != "" {
+		values[field] = r.FormValue("value")
+	} else {
+		r.ParseForm()
+		for k := range r.Form {
+			values[k] = r.Form.Get(k)
+		}
+	}
+	for field, value := range values {
+		switch field {
+		case "name":
+			card.Name = value
+		case "desc":
+			card.Desc = value
+		case "closed":
+			card.Closed = value == "true"
+		case "idList":
+			t.moveCard(card, value)
+		case "pos":
+			if pos, err := strconv.ParseFloat(value, 64); err == nil {
+				card.Pos = pos
+			}
+		}
+	}
+	writeJSON(w, card)
+}
+
+func (t *Trello) moveCard(card *TrelloCard, listId string) {
+	to := t.findList(listId)
+	from := t.findList(card.IdList)
+	if to == nil || from == nil || to == from {
+		return
+	}
+	for index, c := range from.Cards {
+		if c == card {
+			from.Cards = append(from.Cards[:index], from.Cards[index+1:]...)
+			break
+		}
+	}
+	card.IdList = to.Id
+	to.Cards = append([]*TrelloCard{card}, to.Cards...)
+}
+
+func (t *Trello) cardActions(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// newest first like Trello, the fake only keeps comments
+	actions := []TrelloAction{}
+	if filter := r.FormValue("filter"); filter != "" && filter != "commentCard" {
+		writeJSON(w, actions)
+		return
+	}
+	for i := len(card.Comments) - 1; 0 <= i; i-- {
+		actions = append(actions, card.Comments[i])
+	}
+	writeJSON(w, actions)
+}
+
+func (t *Trello) addComment(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	action := TrelloAction{Id: t.id(), Type: "commentCard", Date: time.Now(), Data: TrelloData{Text: r.FormValue("text")}, MemberCreator: t.me}
+	card.Comments = append(card.Comments, action)
+	writeJSON(w, action)
+}
+
+func (t *Trello) addMember(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	member := r.FormValue("value")
+	for _, id := range card.IdMembers {
+		if id == member {
+			writeJSON(w, card.IdMembers)
+			return
+		}
+	}
+	card.IdMembers = append(card.IdMembers, member)
+	writeJSON(w, card.IdMembers)
+}
+
+func (t *Trello) cardAttachments(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, append([]TrelloAttachment{}, card.Files...))
+}
+
+func (t *Trello) cardChecklists(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, append([]TrelloChecklist{}, card.Checklists...))
+}
+
+func (t *Trello) addAttachment(w http.ResponseWriter, r *http.Request) {
+	t.Lock()
+	defer t.Unlock()
+	card := t.findCard(mux.Vars(r)["id"])
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	attachment := TrelloAttachment{Id: t.id(), Name: r.FormValue("name"), Url: r.FormValue("url"), Date: time.Now()}
+	card.Files = append(card.Files, attachment)
+	writeJSON(w, attachment)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
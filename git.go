@@ -3,8 +3,10 @@ package xap_trello
 import (
 	"os"
 	"path"
+	"path/filepath"
 	"os/exec"
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"time"
@@ -13,36 +15,102 @@ import (
 	"golang.org/x/net/context"
 )
 
+// Git is the exec backend of Repository, it runs the git binary found in path (or PATH).
 type Git struct {
-	local, remote, token, path string
+	local, remote, branch, path string
+	// the conflict policy of Rebase, ConflictAbort, ConflictLocal or ConflictRemote
+	policy string
+	credentials GitCredentials
 	log bool
 }
 
-func NewGitRepository(local, remote, token string) *Git {
-	return &Git{local:local, remote:remote, token:token, log:true}
+func NewGitRepository(local, remote, branch string, credentials GitCredentials) *Git {
+	return &Git{local:local, remote:remote, branch:branch, policy:ConflictAbort, credentials:credentials, log:true}
 }
 
 func (git *Git) Init() error {
 	if _, err := os.Stat(path.Join(git.local, ".git")); os.IsNotExist(err) {
-		return <-git.ExecCmd(1 * time.Second, nil, "init")
+		if err := os.MkdirAll(git.local, os.ModePerm); err != nil {
+			return err
+		}
+		if err := <-git.ExecCmd(1 * time.Second, nil, "init"); err != nil {
+			return err
+		}
+		return <-git.ExecCmd(1 * time.Second, nil, "symbolic-ref", "HEAD", "refs/heads/" + git.branch)
 	}
 	return nil
 }
 
+func (git *Git) Clone() error {
+	if _, err := os.Stat(path.Join(git.local, ".git")); err == nil {
+		return nil
+	}
+	parent, dir := filepath.Split(filepath.Clean(git.local))
+	if parent == "" {
+		parent = "."
+	}
+	return <-git.execCmdIn(parent, 30 * time.Second, nil, "clone", "--branch", git.branch, git.remote, dir)
+}
+
 func (git *Git) Log() error {
 	ret := <-git.ExecCmd(3 * time.Second, nil, "log")
 	return ret
 }
 
 func (git *Git) Rebase() error {
-	ret := <-git.ExecCmd(3 * time.Second, nil, "pull", "--rebase", "-X", "ours", git.remote)
+	if err := <-git.ExecCmd(3 * time.Second, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return git.adopt()
+	}
+	arg := []string{"pull", "--rebase"}
+	// while rebasing ours is the remote branch and theirs the replayed local commits
+	switch git.policy {
+	case ConflictLocal:
+		arg = append(arg, "-X", "theirs")
+	case ConflictRemote:
+		arg = append(arg, "-X", "ours")
+	}
+	ret := <-git.ExecCmd(10 * time.Second, nil, append(arg, git.remote, git.branch)...)
+	if isMissingRemoteBranch(ret) {
+		// nothing archived on the branch yet
+		return nil
+	}
+	if _, ok := ret.(*GitConflictError); ok {
+		if err := <-git.ExecCmd(3 * time.Second, nil, "rebase", "--abort"); err != nil {
+			log.Printf("failed to abort the rebase of %s: %s\n", git.local, err.Error())
+		}
+	}
 	return ret
 }
 
+// adopt makes a repository without commits continue the remote history, the local files are
+// kept as changes and the archived files missing locally are checked out.
+func (git *Git) adopt() error {
+	if err := <-git.ExecCmd(30 * time.Second, nil, "fetch", git.remote, git.branch); isMissingRemoteBranch(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := <-git.ExecCmd(3 * time.Second, nil, "reset", "--mixed", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	deleted := &bytes.Buffer{}
+	if err := <-git.ExecCmd(3 * time.Second, deleted, "ls-files", "--deleted"); err != nil {
+		return err
+	}
+	files := []string{}
+	for _, file := range strings.Split(deleted.String(), "\n") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return <-git.ExecCmd(3 * time.Second, nil, append([]string{"checkout", "--"}, files...)...)
+}
+
 func (git *Git) Push() error {
-	git.log = false
-	defer func() {git.log = true}()
-	ret := <-git.ExecCmd(3 * time.Second, nil, "push", git.remoteWithToken(), "master")
+	ret := <-git.ExecCmd(10 * time.Second, nil, "push", git.remote, "HEAD:" + git.branch)
 	return ret
 }
 
@@ -52,52 +120,66 @@ func (git *Git) Add(args... string) error {
 	return ret
 }
 
+func (git *Git) Staged() (bool, error) {
+	output := &bytes.Buffer{}
+	if err := <-git.ExecCmd(3 * time.Second, output, "diff", "--cached", "--name-only"); err != nil {
+		return false, err
+	}
+	return 0 < len(strings.TrimSpace(output.String())), nil
+}
+
 func (git *Git) Commit(message string) error {
 	ret := <-git.ExecCmd(3 * time.Second, nil, "commit", "-m", message)
 	return ret
 }
 
-func (git *Git) remoteWithToken() string {
-	remote := git.remote
-	index := strings.Index(git.remote, "//")
-	if -1 < index{
-		remote = fmt.Sprintf("%s//%s@%s", git.remote[:index], git.token, git.remote[index + 2:])
-	}
-	return remote
+func (git *Git) ExecCmd(timeout time.Duration, withOutput io.Writer, arg ...string) chan error {
+	return git.execCmdIn(git.local, timeout, withOutput, arg...)
 }
 
-func (git *Git) ExecCmd(timeout time.Duration, withOutput io.Writer, arg ...string) chan error {
+func (git *Git) execCmdIn(dir string, timeout time.Duration, withOutput io.Writer, arg ...string) chan error {
 	prompt := strings.Join(arg, " ")
 	showLog := git.log
 	if showLog {
 		log.Printf("Executing 'git %v', timeout is: %s\n", prompt, timeout)
-	}else{
-		prompt = "*** > "
 	}
 	res := make(chan error, 1)
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	cmd := exec.CommandContext(ctx, fmt.Sprintf("%sgit", git.path), arg...)
-	cmd.Dir = git.local
+	env, options, cleanup := []string{}, []string{}, func() {}
+	if git.credentials != nil {
+		var err error
+		if env, options, cleanup, err = git.credentials.Exec(); err != nil {
+			res <- err
+			close(res)
+			return res
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cmd := exec.CommandContext(ctx, fmt.Sprintf("%sgit", git.path), append(options, arg...)...)
+	cmd.Dir = dir
 
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		res <- err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		res <- err
-	}
+	// the output is kept to tell conflicts and authentication failures from other errors
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	printStdout(showLog, prompt, stdout, withOutput)
-	printStderr(showLog, prompt, stderr)
 	if err := cmd.Start(); err != nil {
+		cancel()
+		cleanup()
 		res <- err
+		close(res)
+		return res
 	}
 	go func() {
-		if err := cmd.Wait(); err != nil {
-			res <- err
+		defer cancel()
+		defer cleanup()
+		err := cmd.Wait()
+		printStdout(showLog, prompt, stdout.String(), withOutput)
+		printStderr(showLog, prompt, stderr.String())
+		if err != nil {
+			res <- git.classify(err, stdout.String() + stderr.String())
 		} else {
 			res <- nil
 		}
@@ -107,26 +189,57 @@ func (git *Git) ExecCmd(timeout time.Duration, withOutput io.Writer, arg ...stri
 	return res
 }
 
-func printStdout(showLog bool, prompt string, reader io.ReadCloser, writer  io.Writer) {
-	printReader(showLog, fmt.Sprintf("stdout:%s", prompt), reader, writer)
+// classify turns the exit status of git into a *GitConflictError or *GitAuthError when the output tells so.
+func (git *Git) classify(err error, output string) error {
+	detail := strings.TrimSpace(output)
+	if detail == "" {
+		detail = err.Error()
+	}
+	for _, marker := range []string{"CONFLICT", "could not apply", "non-fast-forward", "[rejected]"} {
+		if strings.Contains(output, marker) {
+			return &GitConflictError{Files: conflictingFiles(output), Detail: detail}
+		}
+	}
+	for _, marker := range []string{"Authentication failed", "could not read Username", "Permission denied (publickey)", "Host key verification failed", "returned error: 403"} {
+		if strings.Contains(output, marker) {
+			return &GitAuthError{Remote: git.remote, Detail: detail}
+		}
+	}
+	return fmt.Errorf("git: %s: %s", err.Error(), detail)
 }
 
-func printStderr(showLog bool, prompt string, reader io.ReadCloser) {
-	printReader(showLog, fmt.Sprintf("stderr:%s", prompt), reader, nil)
+func isMissingRemoteBranch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "couldn't find remote ref")
 }
-func printReader(showLog bool, prompt string, reader io.ReadCloser, writer io.Writer) {
-	go func() {
-		defer reader.Close()
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
-			text := scanner.Text()
-			if showLog {
-				fmt.Printf("%s: %s\n", prompt, text)
-			}
-			if writer != nil {
-				writer.Write([]byte(fmt.Sprintln(text)))
-			}
+
+// conflictingFiles extracts the file names of the "CONFLICT (content): Merge conflict in <file>" lines.
+func conflictingFiles(output string) []string {
+	files := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if index := strings.Index(line, "Merge conflict in "); strings.HasPrefix(line, "CONFLICT") && -1 < index {
+			files = append(files, strings.TrimSpace(line[index + len("Merge conflict in "):]))
 		}
-	}()
+	}
+	return files
+}
+
+func printStdout(showLog bool, prompt string, output string, writer io.Writer) {
+	printLines(showLog, fmt.Sprintf("stdout:%s", prompt), output, writer)
 }
 
+func printStderr(showLog bool, prompt string, output string) {
+	printLines(showLog, fmt.Sprintf("stderr:%s", prompt), output, nil)
+}
+
+func printLines(showLog bool, prompt string, output string, writer io.Writer) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		text := scanner.Text()
+		if showLog {
+			fmt.Printf("%s: %s\n", prompt, text)
+		}
+		if writer != nil {
+			writer.Write([]byte(fmt.Sprintln(text)))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package xap_trello
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	// an OAuth or personal access token over HTTPS
+	GitAuthToken = "token"
+	// a deploy key, the host key is verified against known_hosts
+	GitAuthSSH = "ssh"
+	// a git credential helper, like store or osxkeychain
+	GitAuthHelper = "helper"
+)
+
+// GitAuthConfig tells how to authenticate to the archive repository.
+type GitAuthConfig struct {
+	// token, ssh or helper, token when empty
+	Method   string `json:"method"`
+	Username string `json:"username"`
+	// a secret reference, see ResolveSecret, the token of the GitHub login when empty
+	Token string `json:"token"`
+	// the private key file of the ssh method
+	SSHKey string `json:"ssh_key"`
+	// a secret reference, see ResolveSecret
+	SSHKeyPassphrase string `json:"ssh_key_passphrase"`
+	// ~/.ssh/known_hosts when empty
+	KnownHosts string `json:"known_hosts"`
+	// the credential.helper of the helper method
+	Helper string `json:"helper"`
+}
+
+// GitCredentials authenticate the two Repository backends without putting secrets in urls or arguments.
+type GitCredentials interface {
+	// Exec returns the environment and the leading options of a git command, cleanup removes
+	// whatever was created for it.
+	Exec() (env []string, options []string, cleanup func(), err error)
+	// Transport returns the go-git authentication for remote.
+	Transport(remote string) (transport.AuthMethod, error)
+}
+
+// NewGitCredentials returns the credentials of config.
+func NewGitCredentials(config GitAuthConfig) (GitCredentials, error) {
+	switch config.Method {
+	case "", GitAuthToken:
+		token, err := ResolveSecret(config.Token)
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			source, err := GitHubTokenSource()
+			if err != nil {
+				return nil, fmt.Errorf("no archive token configured and no GitHub login: %s", err.Error())
+			}
+			githubToken, err := source.Token()
+			if err != nil {
+				return nil, fmt.Errorf("the token of the GitHub login can not be refreshed: %s", err.Error())
+			}
+			token = githubToken.AccessToken
+		}
+		return &TokenCredentials{Username: config.Username, Token: token}, nil
+	case GitAuthSSH:
+		if config.SSHKey == "" {
+			return nil, fmt.Errorf("the ssh archive authentication needs ssh_key")
+		}
+		passphrase, err := ResolveSecret(config.SSHKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		knownHosts := config.KnownHosts
+		if knownHosts == "" {
+			knownHosts = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+		}
+		return &SSHKeyCredentials{User: config.Username, KeyFile: config.SSHKey, Passphrase: passphrase, KnownHosts: knownHosts}, nil
+	case GitAuthHelper:
+		if config.Helper == "" {
+			return nil, fmt.Errorf("the helper archive authentication needs helper")
+		}
+		return &CredentialHelper{Helper: config.Helper}, nil
+	}
+	return nil, fmt.Errorf("unknown archive authentication %q, expected %s, %s or %s", config.Method, GitAuthToken, GitAuthSSH, GitAuthHelper)
+}
+
+// TokenCredentials answer the password prompt of git through GIT_ASKPASS, the token is only
+// passed in the environment of the askpass script.
+type TokenCredentials struct {
+	Username string
+	Token    string
+}
+
+const askPassScript = `#!/bin/sh
+case "$1" in
+Username*) echo "$XAP_GIT_USERNAME" ;;
+*) echo "$XAP_GIT_TOKEN" ;;
+esac
+`
+
+func (c *TokenCredentials) username() string {
+	if c.Username == "" {
+		return "x-access-token"
+	}
+	return c.Username
+}
+
+func (c *TokenCredentials) Exec() ([]string, []string, func(), error) {
+	dir, err := ioutil.TempDir("", "xap-git")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	script := filepath.Join(dir, "askpass.sh")
+	if err := ioutil.WriteFile(script, []byte(askPassScript), 0700); err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	env := []string{"GIT_ASKPASS=" + script, "XAP_GIT_USERNAME=" + c.username(), "XAP_GIT_TOKEN=" + c.Token}
+	// a helper configured on the machine must not answer instead of the token
+	return env, []string{"-c", "credential.helper="}, cleanup, nil
+}
+
+func (c *TokenCredentials) Transport(remote string) (transport.AuthMethod, error) {
+	return &githttp.BasicAuth{Username: c.username(), Password: c.Token}, nil
+}
+
+// SSHKeyCredentials use a deploy key, hosts missing from KnownHosts are refused.
+type SSHKeyCredentials struct {
+	User       string
+	KeyFile    string
+	Passphrase string
+	KnownHosts string
+}
+
+func (c *SSHKeyCredentials) user() string {
+	if c.User == "" {
+		return "git"
+	}
+	return c.User
+}
+
+func (c *SSHKeyCredentials) Exec() ([]string, []string, func(), error) {
+	if c.Passphrase != "" {
+		return nil, nil, nil, fmt.Errorf("the exec git backend can not use the passphrase protected key %s, use the go-git backend", c.KeyFile)
+	}
+	command := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s",
+		shellQuote(c.KeyFile), shellQuote(c.KnownHosts))
+	return []string{"GIT_SSH_COMMAND=" + command}, nil, func() {}, nil
+}
+
+func (c *SSHKeyCredentials) Transport(remote string) (transport.AuthMethod, error) {
+	keys, err := gitssh.NewPublicKeysFromFile(c.user(), c.KeyFile, c.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	callback, err := gitssh.NewKnownHostsCallback(c.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("known hosts %s: %s", c.KnownHosts, err.Error())
+	}
+	keys.HostKeyCallback = callback
+	return keys, nil
+}
+
+// CredentialHelper asks a git credential helper, Helper has the syntax of credential.helper.
+type CredentialHelper struct {
+	Helper string
+}
+
+func (c *CredentialHelper) Exec() ([]string, []string, func(), error) {
+	return nil, []string{"-c", "credential.helper=", "-c", "credential.helper=" + c.Helper}, func() {}, nil
+}
+
+// Transport runs the helper the way git does and uses the username and password it returns.
+func (c *CredentialHelper) Transport(remote string) (transport.AuthMethod, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return nil, err
+	}
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(c.Helper, "!"):
+		cmd = exec.Command("sh", "-c", strings.TrimPrefix(c.Helper, "!")+" get")
+	case filepath.IsAbs(c.Helper):
+		cmd = exec.Command("sh", "-c", c.Helper+" get")
+	default:
+		cmd = exec.Command("sh", "-c", "git credential-"+c.Helper+" get")
+	}
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n", u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s: %s %s", c.Helper, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	auth := &githttp.BasicAuth{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "username=") {
+			auth.Username = strings.TrimPrefix(line, "username=")
+		}
+		if strings.HasPrefix(line, "password=") {
+			auth.Password = strings.TrimPrefix(line, "password=")
+		}
+	}
+	if auth.Password == "" {
+		return nil, &GitAuthError{Remote: remote, Detail: fmt.Sprintf("credential helper %s has no password for %s", c.Helper, u.Host)}
+	}
+	return auth, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
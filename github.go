@@ -2,94 +2,267 @@ package xap_trello
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
 	githuboauth "golang.org/x/oauth2/github"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 )
 
-var (
-	// You must register the app at https://github.com/settings/applications
-	// Set callback to http://127.0.0.1:7000/github_oauth_cb
-	// Set ClientId and ClientSecret to
-	oauthConf = &oauth2.Config{
-		ClientID:     "",
-		ClientSecret: "",
-		// select level of access you want https://developer.github.com/v3/oauth/#scopes
-		Scopes:   []string{"user:email", "public_repo"},
-		Endpoint: githuboauth.Endpoint,
-	}
-	// random string for oauth2 API calls to protect against CSRF
-	oauthStateString = "thisshouldberandom"
-)
-
-const htmlIndex = `<html><body>
-Logged in with <a href="/login">GitHub</a>
-</body></html>
-`
 const TOKEN_FILE_NAME = "github-token.json"
 
-// /
-func HandleMain(w http.ResponseWriter, r *http.Request) {
-	config := &Oauth2Config{}
-	if err := FromJSONFile(config, "github-secret.json"); err != nil {
-		fmt.Printf("Error in reading github client secret from file github-secret.json error is: %s\n", err.Error())
+// the cookie holding the state of a login in progress
+const GITHUB_STATE_COOKIE = "github_oauth_state"
+
+// the path of the login callback, registered as the callback url of the GitHub OAuth app
+const GITHUB_CALLBACK_PATH = "/api/github/callback"
+
+var DEFAULT_GITHUB_SCOPES = []string{"user:email", "public_repo"}
+
+type GitHubConfig struct {
+	// the repositories pull requests are linked from, as owner/repo
+	Repositories []string `json:"repositories"`
+	// how often the pull requests are scanned by the server, like "10m", never when empty
+	ScanInterval string `json:"scan_interval"`
+	// a card of the Done list counts as in progress for the burndown until its pull requests are merged
+	CodeComplete bool `json:"code_complete"`
+	// a secret reference, see ResolveSecret, the key of the X-Hub-Signature-256 of the webhooks
+	WebhookSecret string `json:"webhook_secret"`
+	// the OAuth app of the login, the secrets are secret references
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// the stored token is encrypted with this secret reference
+	TokenKey string `json:"token_key"`
+	// https://github.example.com for GitHub Enterprise, github.com when empty
+	BaseUrl string `json:"base_url"`
+	// the login callback, derived from the request when empty
+	RedirectUrl string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes"`
+}
+
+// GitHubOAuthConfig returns the OAuth config of the login, with its endpoints on BaseUrl for GitHub Enterprise.
+func GitHubOAuthConfig(redirectUrl string) (*oauth2.Config, error) {
+	config := ReadConfig().GitHub
+	secret, err := ResolveSecret(config.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := githuboauth.Endpoint
+	if config.BaseUrl != "" {
+		base := strings.TrimSuffix(config.BaseUrl, "/")
+		endpoint = oauth2.Endpoint{AuthURL: base + "/login/oauth/authorize", TokenURL: base + "/login/oauth/access_token"}
+	}
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = DEFAULT_GITHUB_SCOPES
 	}
+	if config.RedirectUrl != "" {
+		redirectUrl = config.RedirectUrl
+	}
+	return &oauth2.Config{ClientID: config.ClientId, ClientSecret: secret, Scopes: scopes, Endpoint: endpoint, RedirectURL: redirectUrl}, nil
+}
 
-	oauthConf.ClientID, oauthConf.ClientSecret = config.ClientID, config.ClientSecret
+// githubRedirectUrl is the callback url on the host the login was requested from.
+func githubRedirectUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + GITHUB_CALLBACK_PATH
+}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(htmlIndex))
+// newOAuthState returns a random state for a login, it is kept in a cookie of the browser that logs in
+// and compared with the state GitHub sends back.
+func newOAuthState() (string, error) {
+	state := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, state); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state), nil
 }
 
-// /login
-func HandleGitHubLogin(w http.ResponseWriter, r *http.Request) {
-	url := oauthConf.AuthCodeURL(oauthStateString, oauth2.AccessTypeOffline)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+// GitHubClient returns a client authorized by the stored token, on BaseUrl for GitHub Enterprise.
+func GitHubClient() (*github.Client, error) {
+	source, err := GitHubTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := oauth2.NewClient(context.Background(), source)
+	config := ReadConfig().GitHub
+	if config.BaseUrl == "" {
+		return github.NewClient(httpClient), nil
+	}
+	base := strings.TrimSuffix(config.BaseUrl, "/")
+	return github.NewEnterpriseClient(base+"/api/v3/", base+"/api/uploads/", httpClient)
 }
 
-// /github_oauth_cb. Called by github after authorization is granted
-func HandleGitHubCallback(w http.ResponseWriter, r *http.Request) {
-	state := r.FormValue("state")
-	if state != oauthStateString {
-		fmt.Printf("invalid oauth state, expected '%s', got '%s'\n", oauthStateString, state)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+// GitHubTokenSource returns the stored token, refreshed when it expires, a refreshed token is stored again.
+func GitHubTokenSource() (oauth2.TokenSource, error) {
+	token, err := ReadGithubToken()
+	if err != nil {
+		return nil, err
+	}
+	config, err := GitHubOAuthConfig("")
+	if err != nil {
+		return nil, err
 	}
+	return oauth2.ReuseTokenSource(token, &storingTokenSource{base: config.TokenSource(context.Background(), token), last: token,
+		name: "GitHub", save: SaveGitHubToken}), nil
+}
 
-	code := r.FormValue("code")
-	token, err := oauthConf.Exchange(oauth2.NoContext, code)
+// storingTokenSource saves the tokens that base refreshed, it is called under the lock of ReuseTokenSource.
+type storingTokenSource struct {
+	base oauth2.TokenSource
+	last *oauth2.Token
+	name string
+	save func(token *oauth2.Token) error
+}
+
+func (s *storingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
 	if err != nil {
-		fmt.Printf("oauthConf.Exchange() failed with '%s'\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		return nil, err
+	}
+	if token.AccessToken != s.last.AccessToken {
+		log.Printf("%s token refreshed, valid until %v\n", s.name, token.Expiry)
+		if err := s.save(token); err != nil {
+			log.Printf("Failed to store the refreshed %s token, error is: %s\n", s.name, err.Error())
+		}
+		s.last = token
 	}
+	return token, nil
+}
+
+// encryptedToken is the content of a token file, the token json sealed with AES-GCM.
+type encryptedToken struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+	// identifies the configured credential the token was refreshed from
+	Origin string `json:"origin,omitempty"`
+}
+
+// tokenCipher is the AES-256-GCM cipher keyed by the sha256 of the keyRef secret, setting names it in errors.
+func tokenCipher(keyRef, setting string) (cipher.AEAD, error) {
+	secret, err := ResolveSecret(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%s of %s is not set, tokens are not stored in clear", setting, CONFIG_FILE_NAME)
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
 
+// saveEncryptedToken stores token in path encrypted with keyRef, the file is only readable by its owner.
+func saveEncryptedToken(path, keyRef, setting, origin string, token *oauth2.Token) error {
+	aead, err := tokenCipher(keyRef, setting)
+	if err != nil {
+		return err
+	}
 	jsonToken, err := tokenToJSON(token)
 	if err != nil {
-		fmt.Printf("convert token to json failed with '%s'\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		return err
+	}
+	sealed := encryptedToken{Nonce: make([]byte, aead.NonceSize()), Origin: origin}
+	if _, err := io.ReadFull(rand.Reader, sealed.Nonce); err != nil {
+		return err
 	}
-	err = ioutil.WriteFile(TOKEN_FILE_NAME, []byte(jsonToken), 0666)
+	sealed.Data = aead.Seal(nil, sealed.Nonce, []byte(jsonToken), []byte(path))
+	bytes, err := json.Marshal(sealed)
 	if err != nil {
-		fmt.Printf("failed to write token to file %s, error is:'%s'\n", TOKEN_FILE_NAME, err)
+		return err
 	}
-	oauthClient := oauthConf.Client(oauth2.NoContext, token)
-	client := github.NewClient(oauthClient)
-	user, _, err := client.Users.Get(context.Background(), "")
+	if err := ioutil.WriteFile(path, bytes, 0600); err != nil {
+		return err
+	}
+	// a file written by an older version keeps its mode otherwise
+	return os.Chmod(path, 0600)
+}
+
+// openEncryptedToken decrypts a token stored by saveEncryptedToken and returns its origin.
+func openEncryptedToken(path, keyRef, setting string, sealed encryptedToken) (*oauth2.Token, string, error) {
+	aead, err := tokenCipher(keyRef, setting)
+	if err != nil {
+		return nil, "", err
+	}
+	jsonToken, err := aead.Open(nil, sealed.Nonce, sealed.Data, []byte(path))
+	if err != nil {
+		return nil, "", fmt.Errorf("can not decrypt %s, was %s changed? %s", path, setting, err.Error())
+	}
+	token, err := TokenFromJSON(string(jsonToken))
+	return token, sealed.Origin, err
+}
+
+// SaveGitHubToken stores token encrypted, the file is only readable by its owner.
+func SaveGitHubToken(token *oauth2.Token) error {
+	return saveEncryptedToken(TOKEN_FILE_NAME, ReadConfig().GitHub.TokenKey, "github.token_key", "", token)
+}
+
+// ReadGithubToken reads the stored token. A token stored in clear by an older version is encrypted in place.
+func ReadGithubToken() (*oauth2.Token, error) {
+	bytes, err := ioutil.ReadFile(TOKEN_FILE_NAME)
 	if err != nil {
-		fmt.Printf("client.Users.Get() faled with '%s'\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		return nil, err
+	}
+	sealed := encryptedToken{}
+	if err := json.Unmarshal(bytes, &sealed); err != nil {
+		return nil, err
 	}
-	fmt.Printf("Logged in as GitHub user: %s\n", *user.Login)
+	if sealed.Data == nil {
+		token, err := TokenFromJSON(string(bytes))
+		if err != nil {
+			return nil, err
+		}
+		if err := SaveGitHubToken(token); err != nil {
+			log.Printf("%s is stored in clear, it can not be encrypted: %s\n", TOKEN_FILE_NAME, err.Error())
+		}
+		return token, nil
+	}
+	token, _, err := openEncryptedToken(TOKEN_FILE_NAME, ReadConfig().GitHub.TokenKey, "github.token_key", sealed)
+	return token, err
+}
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+// GitHubLogin is the user of the stored token, served by GET /api/github.
+type GitHubLogin struct {
+	LoggedIn bool   `json:"logged_in"`
+	Login    string `json:"login,omitempty"`
+	BaseUrl  string `json:"base_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func CurrentGitHubLogin() GitHubLogin {
+	res := GitHubLogin{BaseUrl: ReadConfig().GitHub.BaseUrl}
+	client, err := GitHubClient()
+	if os.IsNotExist(err) {
+		return res
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	user, _, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.LoggedIn, res.Login = true, user.GetLogin()
+	return res
 }
 
 func tokenToJSON(token *oauth2.Token) (string, error) {
@@ -108,11 +281,6 @@ func TokenFromJSON(jsonStr string) (*oauth2.Token, error) {
 	return &token, nil
 }
 
-type Oauth2Config struct {
-	ClientID     string
-	ClientSecret string
-}
-
 func ToJSONFile(val interface{}, filename string) error {
 	if bytes, err := json.Marshal(val); err != nil {
 		return err
@@ -128,11 +296,3 @@ func FromJSONFile(val interface{}, filename string) error {
 	}
 	return json.Unmarshal(jsonBytes, val)
 }
-
-func ReadGithubToken() (*oauth2.Token, error) {
-	jsonToken, err := ioutil.ReadFile(TOKEN_FILE_NAME)
-	if err != nil {
-		return nil, err
-	}
-	return TokenFromJSON(string(jsonToken))
-}
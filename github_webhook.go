@@ -0,0 +1,252 @@
+package xap_trello
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// the events kept for GET /api/webhooks/github
+const GITHUB_EVENTS_KEPT = 100
+
+// GitHubEventCard is what an event did to a card.
+type GitHubEventCard struct {
+	CardId string `json:"card_id"`
+	// the pull request as owner/repo#number, or the commit
+	Reference string `json:"reference"`
+	// linked, updated or unchanged for pull requests, started or unchanged for commits
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	GitHubEventQueued  = "queued"
+	GitHubEventHandled = "handled"
+)
+
+// GitHubEventRecord is a received GitHub webhook and its outcome.
+type GitHubEventRecord struct {
+	Time     time.Time `json:"time"`
+	Delivery string    `json:"delivery"`
+	Event    string    `json:"event"`
+	// queued until the event is handled
+	Status     string            `json:"status"`
+	Action     string            `json:"action,omitempty"`
+	Repository string            `json:"repository,omitempty"`
+	Summary    string            `json:"summary"`
+	Cards      []GitHubEventCard `json:"cards"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// GitHubEventLog keeps the last received webhooks in memory.
+type GitHubEventLog struct {
+	sync.Mutex
+	size   int
+	events []GitHubEventRecord
+}
+
+func NewGitHubEventLog(size int) *GitHubEventLog {
+	return &GitHubEventLog{size: size, events: []GitHubEventRecord{}}
+}
+
+func (l *GitHubEventLog) Add(record GitHubEventRecord) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, record)
+	if l.size < len(l.events) {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+}
+
+// Update replaces the record of the same delivery.
+func (l *GitHubEventLog) Update(record GitHubEventRecord) {
+	l.Lock()
+	defer l.Unlock()
+	for index := range l.events {
+		if l.events[index].Delivery == record.Delivery {
+			l.events[index] = record
+			return
+		}
+	}
+}
+
+// Recent returns the events, the newest first.
+func (l *GitHubEventLog) Recent() []GitHubEventRecord {
+	l.Lock()
+	defer l.Unlock()
+	res := make([]GitHubEventRecord, 0, len(l.events))
+	for index := len(l.events) - 1; 0 <= index; index-- {
+		res = append(res, l.events[index])
+	}
+	return res
+}
+
+// SignGitHubPayload returns the X-Hub-Signature-256 GitHub sends with body.
+func SignGitHubPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyGitHubWebhook(r *http.Request, body []byte, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("no github webhook secret is configured")
+	}
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		return fmt.Errorf("the webhook is not signed")
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignGitHubPayload(secret, body))) {
+		return fmt.Errorf("bad webhook signature")
+	}
+	return nil
+}
+
+// HandleGitHubEvent links the pull request of a pull_request event to its cards and starts the cards referenced
+// by the commits of a push event, other events are recorded and ignored.
+func HandleGitHubEvent(event, delivery string, payload []byte) GitHubEventRecord {
+	record := GitHubEventRecord{Time: time.Now(), Delivery: delivery, Event: event, Status: GitHubEventHandled, Cards: []GitHubEventCard{}}
+	parsed, err := github.ParseWebHook(event, payload)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	switch e := parsed.(type) {
+	case *github.PingEvent:
+		record.Summary = "ping " + e.GetZen()
+	case *github.PullRequestEvent:
+		record.Action, record.Repository = e.GetAction(), e.Repo.GetFullName()
+		err = linkPullRequestEvent(e, &record)
+	case *github.PushEvent:
+		record.Repository = e.Repo.GetFullName()
+		err = startCommittedCards(e, &record)
+	default:
+		record.Summary = "ignored"
+	}
+	if err != nil {
+		log.Printf("Failed to handle github %s event %s, error is: %s\n", event, delivery, err.Error())
+		record.Error = err.Error()
+	}
+	return record
+}
+
+func linkPullRequestEvent(event *github.PullRequestEvent, record *GitHubEventRecord) error {
+	if event.PullRequest == nil {
+		return fmt.Errorf("no pull request in the event")
+	}
+	record.Summary = fmt.Sprintf("%s#%d %s", record.Repository, event.PullRequest.GetNumber(), event.PullRequest.GetTitle())
+	pullRequestScan.Lock()
+	defer pullRequestScan.Unlock()
+	linker, err := NewPullRequestLinker(nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range linker.Link(record.Repository, event.PullRequest) {
+		record.Cards = append(record.Cards, GitHubEventCard{CardId: item.CardId, Reference: item.Pull, Action: item.Action, Error: item.Error})
+	}
+	return linker.Registry.Save()
+}
+
+// startCommittedCards moves the Planned cards referenced by the pushed commits, or by the branch, to the
+// InProgress list, the roles of the lists are the ones scanOnce counts.
+func startCommittedCards(event *github.PushEvent, record *GitHubEventRecord) error {
+	branch := strings.TrimPrefix(event.GetRef(), "refs/heads/")
+	record.Summary = fmt.Sprintf("%d commit(s) to %s", len(event.Commits), branch)
+	if event.GetDeleted() || len(event.Commits) == 0 {
+		return nil
+	}
+	linker, err := NewPullRequestLinker(nil)
+	if err != nil {
+		return err
+	}
+	board, err := linker.Trello.Board("XAP Scrum")
+	if err != nil {
+		return err
+	}
+	lists, err := board.Lists()
+	if err != nil {
+		return err
+	}
+	if len(lists) < len(listRoles) {
+		return fmt.Errorf("the board has %d lists, expected at least %d", len(lists), len(listRoles))
+	}
+	inProgress, planned := lists[1], lists[2]
+	directory, err := LoadIdentities()
+	if err != nil {
+		log.Printf("Failed to read the identities, error is: %s\n", err.Error())
+		directory = &IdentityDirectory{}
+	}
+	seen := map[string]bool{}
+	for _, commit := range event.Commits {
+		reference := commit.GetID()
+		if 7 < len(reference) {
+			reference = reference[:7]
+		}
+		for _, ref := range linker.referencedCards(commit.GetMessage(), "", branch) {
+			if seen[ref.Id] {
+				continue
+			}
+			seen[ref.Id] = true
+			item := GitHubEventCard{CardId: ref.Id, Reference: reference, Action: ImportUnchanged}
+			member := committerMember(directory, event, commit)
+			if err := startCard(linker.Trello, ref.Id, planned.Id, inProgress.Id, member, commit.GetURL()); err == errCardNotPlanned {
+				// already started, or done
+			} else if err != nil {
+				log.Printf("Failed to start card %s by commit %s, error is: %s\n", ref.Id, reference, err.Error())
+				item.Action, item.Error = ImportFailed, err.Error()
+			} else {
+				item.Action = "started"
+			}
+			record.Cards = append(record.Cards, item)
+		}
+	}
+	return nil
+}
+
+var errCardNotPlanned = fmt.Errorf("the card is not planned")
+
+// committerMember is the Trello member of the author of commit, or of the pusher when the author has no GitHub
+// login, "" when neither has an identity.
+func committerMember(directory *IdentityDirectory, event *github.PushEvent, commit github.PushEventCommit) string {
+	login := commit.GetAuthor().GetLogin()
+	if login == "" {
+		login = event.GetPusher().GetLogin()
+	}
+	if login == "" {
+		// the pusher of a push webhook only has a name, which is the login
+		login = event.GetPusher().GetName()
+	}
+	if identity, ok := directory.ByGitHub(login); ok {
+		return identity.TrelloId
+	}
+	return ""
+}
+
+// startCard moves a planned card to in progress and adds the member that committed to it, when known.
+func startCard(xapTrello *Trello, cardId, plannedId, inProgressId, memberId, commitUrl string) error {
+	card, err := xapTrello.Card(cardId)
+	if err != nil {
+		return err
+	}
+	if card.IdList != plannedId {
+		return errCardNotPlanned
+	}
+	log.Printf("Moving card %q to in progress, first commit %s\n", card.Name, commitUrl)
+	if err := xapTrello.MoveCard(cardId, inProgressId); err != nil {
+		return err
+	}
+	if memberId != "" {
+		if err := xapTrello.AddMember(cardId, memberId); err != nil {
+			return err
+		}
+	}
+	return xapTrello.AddComment(cardId, fmt.Sprintf("Started by commit %s", commitUrl))
+}
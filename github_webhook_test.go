@@ -0,0 +1,109 @@
+package xap_trello
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// postGitHubWebhook sends the payload of fake/fixtures/github to the webhook handler, signed when signature is set,
+// and waits for the event to be handled.
+func postGitHubWebhook(t *testing.T, handler http.HandlerFunc, events *GitHubEventLog, dir, event, signature string) (*httptest.ResponseRecorder, GitHubEventRecord) {
+	payload, err := ioutil.ReadFile(filepath.Join(dir, "fake", "fixtures", "github", event+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/webhooks/github", bytes.NewReader(payload))
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-GitHub-Delivery", "delivery-"+event)
+	switch signature {
+	case "":
+	case "valid":
+		r.Header.Set("X-Hub-Signature-256", SignGitHubPayload("test", payload))
+	default:
+		r.Header.Set("X-Hub-Signature-256", signature)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	record := GitHubEventRecord{}
+	if w.Code != http.StatusAccepted {
+		return w, record
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != GitHubEventQueued {
+		t.Errorf("the webhook is answered with the status %q, expected it queued", record.Status)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, recent := range events.Recent() {
+			if recent.Delivery == record.Delivery && recent.Status == GitHubEventHandled {
+				return w, recent
+			}
+		}
+	}
+	t.Fatalf("the %s event was not handled", event)
+	return w, record
+}
+
+func TestGitHubWebhook(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, restore := withFakeBackend(t)
+	defer restore()
+	writeConfig(t, &Config{GitHub: GitHubConfig{WebhookSecret: "test"},
+		Identities: []Identity{{Name: "Dana Dev", TrelloId: "5800000000000000000000ab", GitHubLogin: "dana-dev"}}})
+	events := NewGitHubEventLog(GITHUB_EVENTS_KEPT)
+	handler := CreateGitHubWebhookHandler(events)
+
+	if w, _ := postGitHubWebhook(t, handler, events, dir, "pull_request", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("an unsigned webhook is answered %d", w.Code)
+	}
+	if w, _ := postGitHubWebhook(t, handler, events, dir, "pull_request", SignGitHubPayload("guess", []byte("{}"))); w.Code != http.StatusUnauthorized {
+		t.Errorf("a webhook with a bad signature is answered %d", w.Code)
+	}
+	if recent := events.Recent(); len(recent) != 0 {
+		t.Errorf("rejected webhooks are recorded: %+v", recent)
+	}
+
+	w, record := postGitHubWebhook(t, handler, events, dir, "pull_request", "valid")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("a signed webhook is answered %d: %s", w.Code, w.Body.String())
+	}
+	if record.Error != "" || len(record.Cards) != 1 || record.Cards[0].CardId != "5800000000000000000000d2" || record.Cards[0].Action != "linked" {
+		t.Errorf("the pull request event did %+v, expected to link the blob store metrics card", record)
+	}
+
+	w, record = postGitHubWebhook(t, handler, events, dir, "push", "valid")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("a signed webhook is answered %d: %s", w.Code, w.Body.String())
+	}
+	if record.Error != "" || len(record.Cards) != 1 || record.Cards[0].CardId != "5800000000000000000000d4" || record.Cards[0].Action != "started" {
+		t.Errorf("the push event did %+v, expected to start the lru eviction card", record)
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := xapTrello.Card("5800000000000000000000d4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, err := xapTrello.ListName(card.IdList); err != nil || list != "In Progress" {
+		t.Errorf("the started card is in list %q (%v)", list, err)
+	}
+	if len(card.IdMembers) != 1 || card.IdMembers[0] != "5800000000000000000000ab" {
+		t.Errorf("the started card has the members %v, expected the author of the commit", card.IdMembers)
+	}
+	if recent := events.Recent(); len(recent) != 2 || recent[0].Event != "push" {
+		t.Errorf("the recorded events are %+v", recent)
+	}
+}
@@ -0,0 +1,427 @@
+package xap_trello
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+const (
+	ARCHIVE_REMOTE_NAME  = "origin"
+	ARCHIVE_AUTHOR_NAME  = "xap-trello"
+	ARCHIVE_AUTHOR_EMAIL = "xap-trello@users.noreply.github.com"
+)
+
+// GoGit is the pure Go backend of Repository, the git binary is not needed.
+type GoGit struct {
+	local, remote, branch string
+	// the conflict policy of Rebase, ConflictAbort, ConflictLocal or ConflictRemote
+	policy      string
+	credentials GitCredentials
+}
+
+func NewGoGitRepository(local, remote, branch string, credentials GitCredentials) *GoGit {
+	return &GoGit{local: local, remote: remote, branch: branch, policy: ConflictAbort, credentials: credentials}
+}
+
+func (g *GoGit) Init() error {
+	if _, err := git.PlainOpen(g.local); err == nil {
+		return nil
+	}
+	repo, err := git.PlainInit(g.local, false)
+	if err != nil {
+		return err
+	}
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(g.branch))
+	if err := repo.Storer.SetReference(head); err != nil {
+		return err
+	}
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: ARCHIVE_REMOTE_NAME, URLs: []string{g.remote}})
+	return err
+}
+
+// open opens the local repository, the origin follows the configured remote.
+func (g *GoGit) open() (*git.Repository, error) {
+	repo, err := git.PlainOpen(g.local)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote(ARCHIVE_REMOTE_NAME)
+	if err == nil && 0 < len(remote.Config().URLs) && remote.Config().URLs[0] == g.remote {
+		return repo, nil
+	}
+	if err == nil {
+		if err := repo.DeleteRemote(ARCHIVE_REMOTE_NAME); err != nil {
+			return nil, err
+		}
+	} else if err != git.ErrRemoteNotFound {
+		return nil, err
+	}
+	log.Printf("setting the %s remote of %s to %s\n", ARCHIVE_REMOTE_NAME, g.local, g.remote)
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: ARCHIVE_REMOTE_NAME, URLs: []string{g.remote}})
+	return repo, err
+}
+
+func (g *GoGit) Clone() error {
+	if _, err := git.PlainOpen(g.local); err == nil {
+		return nil
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	log.Printf("cloning %s into %s\n", g.remote, g.local)
+	_, err = git.PlainClone(g.local, false, &git.CloneOptions{
+		URL:           g.remote,
+		Auth:          auth,
+		RemoteName:    ARCHIVE_REMOTE_NAME,
+		ReferenceName: plumbing.NewBranchReferenceName(g.branch),
+		SingleBranch:  true,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		// nothing archived yet, start a repository that pushes to the remote
+		os.RemoveAll(g.local)
+		return g.Init()
+	}
+	return g.classify(err)
+}
+
+func (g *GoGit) Add(paths ...string) error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, err := worktree.Add(path); err != nil {
+			return fmt.Errorf("git add %s: %s", path, err.Error())
+		}
+	}
+	return nil
+}
+
+func (g *GoGit) Staged() (bool, error) {
+	repo, err := g.open()
+	if err != nil {
+		return false, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return false, err
+	}
+	for _, file := range status {
+		if file.Staging != git.Unmodified && file.Staging != git.Untracked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (g *GoGit) Commit(message string) error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	_, err = worktree.Commit(message, &git.CommitOptions{Author: g.signature()})
+	return err
+}
+
+// Rebase fetches the remote branch and replays the local commits on top of it, go-git can not
+// merge file contents so a file changed on both sides is a conflict, resolved by the policy.
+func (g *GoGit) Rebase() error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	remoteRefName := plumbing.NewRemoteReferenceName(ARCHIVE_REMOTE_NAME, g.branch)
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: ARCHIVE_REMOTE_NAME,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", g.branch, remoteRefName))},
+		Auth:       auth,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		return nil
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return g.classify(err)
+	}
+	remoteRef, err := repo.Reference(remoteRefName, true)
+	if err == plumbing.ErrReferenceNotFound {
+		// the branch does not exist on the remote yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return g.adopt(repo, remoteRef.Hash())
+	}
+	if err != nil {
+		return err
+	}
+	remoteCommit, err := repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return err
+	}
+	localCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	bases, err := localCommit.MergeBase(remoteCommit)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return &GitConflictError{Detail: fmt.Sprintf("%s and %s/%s have no common history", g.local, ARCHIVE_REMOTE_NAME, g.branch)}
+	}
+	base := bases[0]
+	if base.Hash == remoteCommit.Hash {
+		// up to date or ahead of the remote
+		return nil
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if base.Hash == localCommit.Hash {
+		return worktree.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset})
+	}
+
+	local, err := commitsSince(localCommit, base.Hash)
+	if err != nil {
+		return err
+	}
+	remoteChanges, err := changedFiles(base, remoteCommit)
+	if err != nil {
+		return err
+	}
+	replay := make([]map[string]plumbing.Hash, len(local))
+	conflicts := map[string]bool{}
+	for i, commit := range local {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return err
+		}
+		if replay[i], err = changedFiles(parent, commit); err != nil {
+			return err
+		}
+		for name, hash := range replay[i] {
+			if remoteHash, ok := remoteChanges[name]; ok && remoteHash != hash {
+				conflicts[name] = true
+			}
+		}
+	}
+	if 0 < len(conflicts) && g.policy == ConflictRemote {
+		for name := range conflicts {
+			log.Printf("keeping the remote version of %s in %s\n", name, g.local)
+			for _, changes := range replay {
+				delete(changes, name)
+			}
+		}
+	} else if 0 < len(conflicts) && g.policy == ConflictLocal {
+		for name := range conflicts {
+			log.Printf("keeping the local version of %s in %s\n", name, g.local)
+		}
+	} else if 0 < len(conflicts) {
+		files := []string{}
+		for name := range conflicts {
+			files = append(files, name)
+		}
+		sort.Strings(files)
+		return &GitConflictError{Files: files, Detail: fmt.Sprintf("changed locally and on %s/%s", ARCHIVE_REMOTE_NAME, g.branch)}
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset}); err != nil {
+		return err
+	}
+	for i, commit := range local {
+		if len(replay[i]) == 0 {
+			continue
+		}
+		for name, hash := range replay[i] {
+			if err := g.restore(worktree, commit, name, hash.IsZero()); err != nil {
+				return err
+			}
+		}
+		if _, err := worktree.Commit(commit.Message, &git.CommitOptions{Author: &commit.Author, Committer: g.signature()}); err != nil {
+			return err
+		}
+	}
+	log.Printf("rebased %d commit(s) of %s on %s/%s\n", len(local), g.local, ARCHIVE_REMOTE_NAME, g.branch)
+	return nil
+}
+
+// adopt makes a repository without commits continue the remote history, the local files are
+// kept as changes and the archived files missing locally are checked out.
+func (g *GoGit) adopt(repo *git.Repository, remote plumbing.Hash) error {
+	branch := plumbing.NewHashReference(plumbing.NewBranchReferenceName(g.branch), remote)
+	if err := repo.Storer.SetReference(branch); err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: remote, Mode: git.MixedReset}); err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(remote)
+	if err != nil {
+		return err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+	for name, file := range status {
+		if file.Worktree == git.Deleted {
+			if err := g.restore(worktree, commit, name, false); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("%s continues the history of %s/%s\n", g.local, ARCHIVE_REMOTE_NAME, g.branch)
+	return nil
+}
+
+func (g *GoGit) Push() error {
+	repo, err := g.open()
+	if err != nil {
+		return err
+	}
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+	refSpec := fmt.Sprintf("refs/heads/%s:refs/heads/%s", g.branch, g.branch)
+	err = repo.Push(&git.PushOptions{
+		RemoteName: ARCHIVE_REMOTE_NAME,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(refSpec)},
+		Auth:       auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return g.classify(err)
+}
+
+// restore writes the content name has in commit to the worktree and the index, or removes it when deleted.
+func (g *GoGit) restore(worktree *git.Worktree, commit *object.Commit, name string, deleted bool) error {
+	if deleted {
+		_, err := worktree.Remove(name)
+		return err
+	}
+	file, err := commit.File(name)
+	if err != nil {
+		return err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(g.local, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		return err
+	}
+	_, err = worktree.Add(name)
+	return err
+}
+
+func (g *GoGit) auth() (transport.AuthMethod, error) {
+	if g.credentials == nil {
+		return nil, nil
+	}
+	return g.credentials.Transport(g.remote)
+}
+
+func (g *GoGit) signature() *object.Signature {
+	return &object.Signature{Name: ARCHIVE_AUTHOR_NAME, Email: ARCHIVE_AUTHOR_EMAIL, When: time.Now()}
+}
+
+func (g *GoGit) classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == transport.ErrAuthenticationRequired || err == transport.ErrAuthorizationFailed {
+		return &GitAuthError{Remote: g.remote, Detail: err.Error()}
+	}
+	if err == git.ErrNonFastForwardUpdate || strings.Contains(err.Error(), "non-fast-forward") {
+		return &GitConflictError{Detail: err.Error()}
+	}
+	return err
+}
+
+// commitsSince returns the first parent chain from commit back to base (excluded), oldest first.
+func commitsSince(commit *object.Commit, base plumbing.Hash) ([]*object.Commit, error) {
+	commits := []*object.Commit{}
+	for commit.Hash != base {
+		commits = append([]*object.Commit{commit}, commits...)
+		if commit.NumParents() == 0 {
+			return nil, fmt.Errorf("commit %s is not an ancestor of %s", base, commits[len(commits)-1].Hash)
+		}
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		commit = parent
+	}
+	return commits, nil
+}
+
+// changedFiles maps the files changed between from and to to their new blob hash, zero when deleted.
+func changedFiles(from, to *object.Commit) (map[string]plumbing.Hash, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]plumbing.Hash{}
+	for _, change := range changes {
+		if change.To.Name == "" {
+			files[change.From.Name] = plumbing.ZeroHash
+			continue
+		}
+		files[change.To.Name] = change.To.TreeEntry.Hash
+		if change.From.Name != "" && change.From.Name != change.To.Name {
+			files[change.From.Name] = plumbing.ZeroHash
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,135 @@
+package xap_trello
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// gitClone clones remote into dir with the go-git backend.
+func gitClone(t *testing.T, remote, dir, policy string) *GoGit {
+	repo, err := NewArchiveRepository(ArchiveConfig{Remote: remote, Auth: GitAuthConfig{Token: "x"}, Conflict: policy}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Clone(); err != nil {
+		t.Fatal(err)
+	}
+	return repo.(*GoGit)
+}
+
+// gitCommit writes the files to the clone and commits them.
+func gitCommit(t *testing.T, repo *GoGit, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(repo.local, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Commit("update"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gitContent(t *testing.T, repo *GoGit, name string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(repo.local, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+// twoClones returns two clones of a new remote that share a first commit of a.json.
+func twoClones(t *testing.T, policy string) (*GoGit, *GoGit) {
+	remote, err := filepath.Abs("remote.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	first := gitClone(t, remote, "first", policy)
+	gitCommit(t, first, map[string]string{"a.json": "base"})
+	if err := first.Push(); err != nil {
+		t.Fatal(err)
+	}
+	return first, gitClone(t, remote, "second", policy)
+}
+
+func TestGoGitRebaseReplaysLocalCommits(t *testing.T) {
+	defer inTempDir(t)()
+	first, second := twoClones(t, ConflictAbort)
+
+	gitCommit(t, first, map[string]string{"a.json": "first"})
+	if err := first.Push(); err != nil {
+		t.Fatal(err)
+	}
+	gitCommit(t, second, map[string]string{"b.json": "second"})
+	if err := second.Push(); err == nil {
+		t.Fatal("a push behind the remote is accepted")
+	}
+	if err := second.Rebase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := gitContent(t, second, "a.json"), gitContent(t, second, "b.json"); a != "first" || b != "second" {
+		t.Errorf("the rebased clone has a.json %q and b.json %q", a, b)
+	}
+	// fast forward
+	if err := first.Rebase(); err != nil {
+		t.Fatal(err)
+	}
+	if b := gitContent(t, first, "b.json"); b != "second" {
+		t.Errorf("b.json is %q after the fast forward", b)
+	}
+}
+
+func TestGoGitRebaseConflictPolicies(t *testing.T) {
+	cases := []struct {
+		policy, expected string
+		conflict         bool
+	}{
+		{ConflictAbort, "second", true},
+		{ConflictLocal, "second", false},
+		{ConflictRemote, "first", false},
+	}
+	for _, c := range cases {
+		func() {
+			defer inTempDir(t)()
+			first, second := twoClones(t, c.policy)
+			gitCommit(t, first, map[string]string{"a.json": "first"})
+			if err := first.Push(); err != nil {
+				t.Fatal(err)
+			}
+			gitCommit(t, second, map[string]string{"a.json": "second", "b.json": "second"})
+
+			err := second.Rebase()
+			if c.conflict {
+				conflict, ok := err.(*GitConflictError)
+				if !ok {
+					t.Fatalf("policy %s: rebase returned %v, expected a conflict", c.policy, err)
+				}
+				if !reflect.DeepEqual(conflict.Files, []string{"a.json"}) {
+					t.Errorf("policy %s: conflicting files are %v", c.policy, conflict.Files)
+				}
+			} else if err != nil {
+				t.Fatalf("policy %s: %s", c.policy, err)
+			} else if err := second.Push(); err != nil {
+				t.Fatalf("policy %s: the rebased commit is not pushed: %s", c.policy, err)
+			}
+			if a := gitContent(t, second, "a.json"); a != c.expected {
+				t.Errorf("policy %s: a.json is %q, expected %q", c.policy, a, c.expected)
+			}
+			if b := gitContent(t, second, "b.json"); b != "second" {
+				t.Errorf("policy %s: the change to b.json is lost", c.policy)
+			}
+		}()
+	}
+}
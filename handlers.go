@@ -1,9 +1,14 @@
 package xap_trello
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"github.com/google/go-github/github"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
 	"html/template"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"regexp"
@@ -42,7 +47,7 @@ func CreateTimelineHandler(burndown *Burndown) http.HandlerFunc {
 	}
 }
 
-func CreateNextSprintHandler(burndown *Burndown) http.HandlerFunc {
+func CreateNextSprintHandler(rollover *SprintRollover) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("reading sprint data")
 		decoder := json.NewDecoder(r.Body)
@@ -73,9 +78,131 @@ func CreateNextSprintHandler(burndown *Burndown) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		_ = WriteSprint(Sprint{Name: name, Start: start, End: end})
-		<-burndown.StartNewSprint(name, start, end)
-		w.WriteHeader(http.StatusOK)
+		report, err := rollover.Run(name, start, end)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err == ErrRolloverDone {
+			log.Printf("rollover to sprint %s is already done\n", name)
+			w.WriteHeader(http.StatusConflict)
+		} else if err != nil {
+			log.Printf("error %s\n", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if report != nil {
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				log.Printf("error %s\n", err.Error())
+			}
+		}
+	}
+}
+
+func CreateRolloverStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := LastRollover()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if report == nil {
+			http.Error(w, "no sprint rollover was done", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func CreateLinksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, err := DefaultLinkRegistry()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var res interface{} = links.All()
+		if id, ok := mux.Vars(r)["id"]; ok {
+			link, found := links.Find(id)
+			if !found {
+				http.Error(w, fmt.Sprintf("no link for %q", id), http.StatusNotFound)
+				return
+			}
+			res = link
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateReconcileHandler serves the reconciliation report, ?format=text for plain text, ?lists=n and ?sprint=id
+// override the number of lists and the Jira sprint.
+func CreateReconcileHandler(burndown *Burndown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lists, sprintId := 3, -1
+		var err error
+		if v := r.FormValue("lists"); v != "" {
+			if lists, err = strconv.Atoi(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := r.FormValue("sprint"); v != "" {
+			if sprintId, err = strconv.Atoi(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := Reconcile(lists, sprintId, burndown)
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			report.WriteText(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateReleaseNotesHandler serves the release notes of the Done list, ?format=markdown for Markdown, ?sprint=name
+// overrides the sprint named after the Done list.
+func CreateReleaseNotesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		xapTrello, err := CreateXAPTrello()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notes, err := CollectReleaseNotes(xapTrello, r.FormValue("sprint"))
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("format") == "markdown" {
+			w.Header().Set("Content-Type", "text/markdown; charset=UTF-8")
+			notes.WriteMarkdown(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(notes); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	}
 }
 
@@ -113,6 +240,268 @@ func CreateGuessSprintParamsHandler() http.HandlerFunc {
 	}
 }
 
+// CreateStatusHandler serves the sprint followed by the burndown and the queue of the archiver.
+func CreateStatusHandler(burndown *Burndown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(burndown.Status()); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateHistoryHandler serves the archived sprints by start date.
+func CreateHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		history, err := ReadHistory()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(history.List()); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateCardPullsHandler serves the pull requests linked to the card with the id or short link.
+func CreateCardPullsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registry, err := ReadPullRequestLinks()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(registry.ByCard(mux.Vars(r)["id"])); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreatePullRequestScanHandler links the pull requests of the configured repositories, ?format=text returns
+// plain text. With github.scan_interval the scan also runs in the background.
+func CreatePullRequestScanHandler() http.HandlerFunc {
+	if config := ReadConfig().GitHub; config.ScanInterval != "" {
+		interval, err := time.ParseDuration(config.ScanInterval)
+		if err != nil || interval <= 0 {
+			log.Printf("error while reading github scan interval %q, pull requests are only scanned on demand\n", config.ScanInterval)
+		} else {
+			StartPullRequestLinker(interval)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := LinkPullRequests()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			report.WriteText(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateGitHubWebhookHandler receives the GitHub webhooks signed with github.webhook_secret, records them in
+// events and answers 202 before they are handled.
+func CreateGitHubWebhookHandler(events *GitHubEventLog) http.HandlerFunc {
+	secret, err := ResolveSecret(ReadConfig().GitHub.WebhookSecret)
+	if err != nil {
+		log.Printf("error while reading github webhook secret: %s\n", err.Error())
+	}
+	// GitHub gives up on a delivery after 10 seconds, the events are handled one at a time after the answer
+	queue := NewWebhookQueue(0)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := verifyGitHubWebhook(r, body, secret); err != nil {
+			log.Printf("Rejected github webhook from %s: %s\n", r.RemoteAddr, err.Error())
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		event, delivery := github.WebHookType(r), github.DeliveryID(r)
+		record := GitHubEventRecord{Time: time.Now(), Delivery: delivery, Event: event, Status: GitHubEventQueued, Cards: []GitHubEventCard{}}
+		events.Add(record)
+		queue.Add("github:"+delivery, func() {
+			events.Update(HandleGitHubEvent(event, delivery, body))
+		})
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(record); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateGitHubEventsHandler serves the recent GitHub webhooks, the newest first.
+func CreateGitHubEventsHandler(events *GitHubEventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(events.Recent()); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateGitHubLoginHandler sends the browser to GitHub to authorize the app, with a random state kept in a cookie.
+func CreateGitHubLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, err := GitHubOAuthConfig(githubRedirectUrl(r))
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		state, err := newOAuthState()
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: GITHUB_STATE_COOKIE, Value: state, Path: GITHUB_CALLBACK_PATH, MaxAge: 600,
+			HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+		http.Redirect(w, r, config.AuthCodeURL(state, oauth2.AccessTypeOffline), http.StatusTemporaryRedirect)
+	}
+}
+
+// CreateGitHubCallbackHandler stores the token GitHub grants when the state matches the cookie of the login.
+func CreateGitHubCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(GITHUB_STATE_COOKIE)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.FormValue("state"))) != 1 {
+			log.Printf("Rejected github login callback from %s, invalid oauth state\n", r.RemoteAddr)
+			http.Error(w, "invalid oauth state, log in again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: GITHUB_STATE_COOKIE, Path: GITHUB_CALLBACK_PATH, MaxAge: -1})
+		if reason := r.FormValue("error"); reason != "" {
+			http.Error(w, fmt.Sprintf("github login failed: %s", reason), http.StatusForbidden)
+			return
+		}
+		config, err := GitHubOAuthConfig(githubRedirectUrl(r))
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token, err := config.Exchange(r.Context(), r.FormValue("code"))
+		if err != nil {
+			log.Printf("github token exchange failed with %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err := SaveGitHubToken(token); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		login := CurrentGitHubLogin()
+		log.Printf("Logged in as GitHub user: %s\n", login.Login)
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	}
+}
+
+// CreateGitHubStatusHandler serves the GitHub user of the stored token.
+func CreateGitHubStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(CurrentGitHubLogin()); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateBurndownSVGHandler serves the chart that the sprint report attaches to Jira.
+func CreateBurndownSVGHandler(burndown *Burndown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(RenderBurndownSVG(burndown.GetSprintStatus()))
+	}
+}
+
+// CreateImportHandler imports the issues of the configured jql query into the board, ?jql= overrides the
+// query and ?format=text returns plain text.
+func CreateImportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := ImportIssues(r.FormValue("jql"))
+		if err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			report.WriteText(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// CreateJiraWebhookHandler receives the Jira webhooks, the events are queued and the handler answers right away.
+func CreateJiraWebhookHandler(burndown *Burndown) http.HandlerFunc {
+	config := ReadConfig().JiraWebhook
+	secret, err := ResolveSecret(config.Secret)
+	if err != nil {
+		log.Printf("error while reading jira webhook secret: %s\n", err.Error())
+	}
+	delay := DEFAULT_WEBHOOK_DELAY
+	if config.Delay != "" {
+		if delay, err = time.ParseDuration(config.Delay); err != nil {
+			log.Printf("error while reading jira webhook delay %q: %s\n", config.Delay, err.Error())
+			delay = DEFAULT_WEBHOOK_DELAY
+		}
+	}
+	queue := NewWebhookQueue(delay)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := verifyJiraWebhook(r, body, secret); err != nil {
+			log.Printf("Rejected jira webhook from %s: %s\n", r.RemoteAddr, err.Error())
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		event := JiraWebhookEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			log.Printf("error %s\n", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Jira webhook %s %s%s\n", event.WebhookEvent, event.Issue.Key, event.Sprint.Name)
+		HandleJiraEvent(queue, burndown, event)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
 func indexOf(strings []string, value string) int {
 	for p, v := range strings {
 		if v == value {
@@ -123,7 +512,7 @@ func indexOf(strings []string, value string) int {
 }
 
 func getNextSprintDefaults() (start, end time.Time, name string, err error) {
-	sprint := ReadSprint()
+	sprint := CurrentSprint()
 	if sprint == nil {
 		return time.Now(), time.Now(), "", fmt.Errorf("error: fail to read current sprint, can't compute next sprint defaults")
 	}
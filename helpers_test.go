@@ -0,0 +1,63 @@
+package xap_trello
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/barakb/xap-trello/fake"
+)
+
+// inTempDir runs the test in an empty working directory, the files of the package are written to
+// the working directory. The returned function goes back and removes it.
+func inTempDir(t *testing.T) func() {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "xap-trello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(dir)
+		os.RemoveAll(tmp)
+	}
+}
+
+// withFakeBackend points the clients at fake servers seeded from fake/fixtures, as -backend=fake does,
+// and runs the test in an empty working directory with a new link registry. The returned function restores both.
+func withFakeBackend(t *testing.T) (*fake.Backend, func()) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := fake.Start(filepath.Join(dir, "fake", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, override := http.DefaultTransport, jiraConfigOverride
+	http.DefaultTransport = servers.Transport(transport)
+	jiraConfigOverride = &JiraConfig{Url: servers.Jira.URL, Auth: JiraAuthConfig{Type: JiraAuthBasic, User: "fake", Token: "fake"}}
+	back := inTempDir(t)
+	// the registry of the previous test was opened in its own directory
+	defaultLinks, defaultLinksErr, defaultLinksOnce = nil, nil, sync.Once{}
+	return servers, func() {
+		back()
+		http.DefaultTransport, jiraConfigOverride = transport, override
+		servers.Close()
+	}
+}
+
+// writeConfig writes xap-trello.json to the working directory.
+func writeConfig(t *testing.T, config *Config) {
+	if err := ToJSONFile(config, CONFIG_FILE_NAME); err != nil {
+		t.Fatal(err)
+	}
+}
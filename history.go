@@ -0,0 +1,70 @@
+package xap_trello
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+const HISTORY_FILE_NAME = "history.json"
+
+// SprintHistory is the summary of a sprint, taken from its archived burndown data.
+type SprintHistory struct {
+	Sprint Sprint `json:"sprint"`
+	// the path of the sprint file in the archive
+	File   string `json:"file"`
+	Events int    `json:"events"`
+	// the last state of the timeline
+	Planned    int       `json:"planned"`
+	InProgress int       `json:"in_progress"`
+	Done       int       `json:"done"`
+	Imported   time.Time `json:"imported"`
+}
+
+// HistoryStore keeps the summary of every archived sprint in history.json, keyed by sprint name.
+type HistoryStore struct {
+	path    string
+	Sprints map[string]*SprintHistory `json:"sprints"`
+}
+
+// ReadHistory reads history.json, a missing file yields an empty store.
+func ReadHistory() (*HistoryStore, error) {
+	store := &HistoryStore{path: HISTORY_FILE_NAME, Sprints: map[string]*SprintHistory{}}
+	if err := FromJSONFile(store, HISTORY_FILE_NAME); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if store.Sprints == nil {
+		store.Sprints = map[string]*SprintHistory{}
+	}
+	return store, nil
+}
+
+// Put replaces the summary of the sprint of data, archived at file.
+func (h *HistoryStore) Put(file string, data *BurnDownData) {
+	entry := &SprintHistory{Sprint: *data.Sprint, File: file, Events: len(data.TrelloEvents), Imported: time.Now()}
+	if 0 < len(data.TrelloEvents) {
+		last := data.TrelloEvents[len(data.TrelloEvents)-1]
+		entry.Planned, entry.InProgress, entry.Done = last.Planned, last.InProgress, last.Done
+	}
+	h.Sprints[data.Sprint.Name] = entry
+}
+
+// List returns the sprints by start date.
+func (h *HistoryStore) List() []*SprintHistory {
+	sprints := sprintHistories{}
+	for _, entry := range h.Sprints {
+		sprints = append(sprints, entry)
+	}
+	sort.Sort(sprints)
+	return sprints
+}
+
+func (h *HistoryStore) Save() error {
+	return ToJSONFile(h, h.path)
+}
+
+type sprintHistories []*SprintHistory
+
+func (s sprintHistories) Len() int           { return len(s) }
+func (s sprintHistories) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sprintHistories) Less(i, j int) bool { return s[i].Sprint.Start.Before(s[j].Sprint.Start) }
@@ -0,0 +1,253 @@
+package xap_trello
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const IDENTITIES_FILE_NAME = "identities.json"
+
+// Identity is one person on Trello, Jira and GitHub.
+type Identity struct {
+	Name           string `json:"name"`
+	Email          string `json:"email,omitempty"`
+	TrelloId       string `json:"trello_id,omitempty"`
+	TrelloUsername string `json:"trello_username,omitempty"`
+	// Atlassian Cloud account id, JiraName is the username of Jira Server
+	JiraAccountId string `json:"jira_account_id,omitempty"`
+	JiraName      string `json:"jira_name,omitempty"`
+	GitHubLogin   string `json:"github_login,omitempty"`
+}
+
+// JiraUser is the value of a Jira user field, such as assignee or reporter, nil when unknown.
+func (i Identity) JiraUser() map[string]string {
+	if i.JiraAccountId != "" {
+		return map[string]string{"accountId": i.JiraAccountId}
+	}
+	if i.JiraName != "" {
+		return map[string]string{"name": i.JiraName}
+	}
+	return nil
+}
+
+// JiraMention notifies the user when put in a Jira comment.
+func (i Identity) JiraMention() string {
+	if i.JiraAccountId != "" {
+		return "[~accountid:" + i.JiraAccountId + "]"
+	}
+	if i.JiraName != "" {
+		return "[~" + i.JiraName + "]"
+	}
+	return ""
+}
+
+// IdentityDirectory merges the identities of the config file with the ones found by MatchIdentities,
+// kept in identities.json. The config file wins, field by field.
+type IdentityDirectory struct {
+	Identities []Identity
+}
+
+func LoadIdentities() (*IdentityDirectory, error) {
+	matched := []Identity{}
+	if err := FromJSONFile(&matched, IDENTITIES_FILE_NAME); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	d := &IdentityDirectory{}
+	for _, identity := range ReadConfig().Identities {
+		d.add(identity)
+	}
+	for _, identity := range matched {
+		d.add(identity)
+	}
+	return d, nil
+}
+
+// add merges identity into the entry of the same person, found by any of its ids, or appends it.
+func (d *IdentityDirectory) add(identity Identity) {
+	for i := range d.Identities {
+		existing := &d.Identities[i]
+		if !existing.samePerson(identity) {
+			continue
+		}
+		fill := func(field *string, value string) {
+			if *field == "" {
+				*field = value
+			}
+		}
+		fill(&existing.Name, identity.Name)
+		fill(&existing.Email, identity.Email)
+		fill(&existing.TrelloId, identity.TrelloId)
+		fill(&existing.TrelloUsername, identity.TrelloUsername)
+		fill(&existing.JiraAccountId, identity.JiraAccountId)
+		fill(&existing.JiraName, identity.JiraName)
+		fill(&existing.GitHubLogin, identity.GitHubLogin)
+		return
+	}
+	d.Identities = append(d.Identities, identity)
+}
+
+func (i Identity) samePerson(other Identity) bool {
+	same := func(a, b string) bool {
+		return a != "" && strings.EqualFold(a, b)
+	}
+	return same(i.TrelloId, other.TrelloId) || same(i.TrelloUsername, other.TrelloUsername) ||
+		same(i.JiraAccountId, other.JiraAccountId) || same(i.JiraName, other.JiraName) ||
+		same(i.GitHubLogin, other.GitHubLogin) || same(i.Email, other.Email)
+}
+
+func (d *IdentityDirectory) find(match func(identity Identity) bool) (Identity, bool) {
+	for _, identity := range d.Identities {
+		if match(identity) {
+			return identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+// ByTrello finds the identity of a Trello member id or username.
+func (d *IdentityDirectory) ByTrello(member string) (Identity, bool) {
+	return d.find(func(identity Identity) bool {
+		return member != "" && (identity.TrelloId == member || strings.EqualFold(identity.TrelloUsername, member))
+	})
+}
+
+// ByJira finds the identity of a Jira account id or username.
+func (d *IdentityDirectory) ByJira(user string) (Identity, bool) {
+	return d.find(func(identity Identity) bool {
+		return user != "" && (identity.JiraAccountId == user || strings.EqualFold(identity.JiraName, user))
+	})
+}
+
+// ByGitHub finds the identity of a GitHub login.
+func (d *IdentityDirectory) ByGitHub(login string) (Identity, bool) {
+	return d.find(func(identity Identity) bool {
+		return login != "" && strings.EqualFold(identity.GitHubLogin, login)
+	})
+}
+
+// TrelloNames returns the names of the Trello members, the member id when there is no identity.
+func (d *IdentityDirectory) TrelloNames(memberIds []string) []string {
+	names := []string{}
+	for _, memberId := range memberIds {
+		if identity, ok := d.ByTrello(memberId); ok && identity.Name != "" {
+			names = append(names, identity.Name)
+		} else {
+			names = append(names, memberId)
+		}
+	}
+	return names
+}
+
+// MatchIdentities looks up every member of the board on Jira and GitHub by email and records what it
+// found in identities.json. Trello only exposes the email of members the token may see, Jira and
+// GitHub only find users whose email is visible.
+func MatchIdentities() (*IdentityDirectory, error) {
+	directory, err := LoadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return nil, err
+	}
+	xapOpenJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		return nil, err
+	}
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		return nil, err
+	}
+	members := []struct {
+		Id string `json:"id"`
+	}{}
+	if err := xapTrello.getJSON("/boards/"+board.Id+"/members", &members); err != nil {
+		return nil, err
+	}
+
+	matched := []Identity{}
+	for _, member := range members {
+		trelloMember := struct {
+			Id       string `json:"id"`
+			Username string `json:"username"`
+			FullName string `json:"fullName"`
+			Email    string `json:"email"`
+		}{}
+		if err := xapTrello.getJSON("/members/"+member.Id+"?fields=username,fullName,email", &trelloMember); err != nil {
+			return nil, err
+		}
+		directory.add(Identity{Name: trelloMember.FullName, Email: trelloMember.Email, TrelloId: trelloMember.Id, TrelloUsername: trelloMember.Username})
+		identity, _ := directory.ByTrello(trelloMember.Id)
+		if identity.Email != "" && identity.JiraUser() == nil {
+			if user, err := xapOpenJira.FindUserByEmail(identity.Email); err != nil {
+				log.Printf("Failed to find jira user of %s, error is %s\n", identity.Email, err.Error())
+			} else if user != nil {
+				identity.JiraAccountId, identity.JiraName = user.AccountId, user.Name
+			}
+		}
+		if identity.Email != "" && identity.GitHubLogin == "" {
+			if login, err := findGitHubLogin(identity.Email); err != nil {
+				log.Printf("Failed to find github user of %s, error is %s\n", identity.Email, err.Error())
+			} else {
+				identity.GitHubLogin = login
+			}
+		}
+		matched = append(matched, identity)
+		directory.add(identity)
+	}
+	bytes, err := json.MarshalIndent(matched, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(IDENTITIES_FILE_NAME, bytes, 0644); err != nil {
+		return nil, err
+	}
+	return directory, nil
+}
+
+func findGitHubLogin(email string) (string, error) {
+	client, err := GitHubClient()
+	if err != nil {
+		return "", err
+	}
+	res, _, err := client.Search.Users(context.Background(), email+" in:email", nil)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Users) != 1 || res.Users[0].Login == nil {
+		return "", nil
+	}
+	return *res.Users[0].Login, nil
+}
+
+// JiraUserInfo is a user as returned by rest/api/2/user/search.
+type JiraUserInfo struct {
+	AccountId    string `json:"accountId"`
+	Name         string `json:"name"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+// FindUserByEmail returns the user with the email, nil if Jira does not show such a user.
+func (j Jira) FindUserByEmail(email string) (*JiraUserInfo, error) {
+	users := []JiraUserInfo{}
+	// Atlassian Cloud searches by query, Jira Server by username
+	err := j.do("GET", "rest/api/2/user/search?"+url.Values{"query": {email}}.Encode(), nil, &users)
+	if err != nil || len(users) == 0 {
+		err = j.do("GET", "rest/api/2/user/search?"+url.Values{"username": {email}}.Encode(), nil, &users)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if strings.EqualFold(user.EmailAddress, email) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
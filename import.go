@@ -0,0 +1,164 @@
+package xap_trello
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportLinked    = "linked"
+	ImportFailed    = "failed"
+)
+
+type ImportConfig struct {
+	Jql string `json:"jql"`
+	// the list that gets the new cards, defaults to the third list of the board
+	List string `json:"list"`
+}
+
+type ImportItem struct {
+	IssueKey string `json:"issue_key"`
+	CardId   string `json:"card_id,omitempty"`
+	CardName string `json:"card_name"`
+	CardUrl  string `json:"card_url,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Jql   string       `json:"jql"`
+	List  string       `json:"list"`
+	Time  time.Time    `json:"time"`
+	Items []ImportItem `json:"items"`
+}
+
+// importedCardName puts the estimate in the card title the way points reads it.
+func importedCardName(issue IssueSummary) string {
+	if issue.Points <= 0 {
+		return issue.Summary
+	}
+	return fmt.Sprintf("%s (%d)", issue.Summary, int(issue.Points+0.5))
+}
+
+// ImportIssues creates a card in the planned list for each issue of the jql query that is not linked
+// to a card yet, the cards an earlier import created get the summary and estimate of the issue.
+func ImportIssues(jql string) (*ImportReport, error) {
+	config := ReadConfig().Import
+	if jql == "" {
+		jql = config.Jql
+	}
+	if jql == "" {
+		return nil, fmt.Errorf("no jql query to import, set import.jql in %s", CONFIG_FILE_NAME)
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return nil, err
+	}
+	xapOpenJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		return nil, err
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return nil, err
+	}
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		return nil, err
+	}
+	trelloLists, err := board.Lists()
+	if err != nil {
+		return nil, err
+	}
+	var listId, listName string
+	for index, aList := range trelloLists {
+		if (config.List == "" && index == 2) || aList.Name == config.List {
+			listId, listName = aList.Id, aList.Name
+			break
+		}
+	}
+	if listId == "" {
+		return nil, fmt.Errorf("no planned list %q on the board", config.List)
+	}
+	issues, err := xapOpenJira.SearchSummaries(jql)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Jql: jql, List: listName, Time: time.Now(), Items: []ImportItem{}}
+	for _, issue := range issues {
+		item := ImportItem{IssueKey: issue.Key, CardName: importedCardName(issue)}
+		if err := importIssue(xapTrello, xapOpenJira, links, listId, issue, &item); err != nil {
+			log.Printf("Failed to import issue %s, error is %s\n", issue.Key, err.Error())
+			item.Action, item.Error = ImportFailed, err.Error()
+		}
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+func importIssue(xapTrello *Trello, xapOpenJira *Jira, links *LinkRegistry, listId string, issue IssueSummary, item *ImportItem) error {
+	if link, ok := links.ByIssue(issue.Key); ok {
+		item.CardId, item.CardUrl = link.CardId, link.CardUrl
+		if !link.Imported {
+			// the card is the source of the issue, its title is not replaced
+			item.CardName, item.Action = link.CardName, ImportUnchanged
+			return nil
+		}
+		card, err := xapTrello.Card(link.CardId)
+		if err != nil {
+			return err
+		}
+		if card.Name == item.CardName {
+			item.Action = ImportUnchanged
+			return nil
+		}
+		if err := xapTrello.SetCardName(card.Id, item.CardName); err != nil {
+			return err
+		}
+		link.CardName = item.CardName
+		item.Action = ImportUpdated
+		log.Printf("Updated card %q of %s\n", item.CardName, issue.Key)
+		return links.Put(link)
+	}
+
+	// a card that is not in the registry yet, e.g. linked by hand
+	cardUrl, err := xapOpenJira.TrelloCard(issue.Key)
+	if err != nil {
+		return err
+	}
+	if found := cardUrlPattern.FindStringSubmatch(cardUrl); found != nil {
+		card, err := xapTrello.Card(found[1])
+		if err != nil {
+			return fmt.Errorf("fail to read card %s of %s: %s", cardUrl, issue.Key, err.Error())
+		}
+		item.CardId, item.CardUrl, item.CardName, item.Action = card.Id, card.Url, card.Name, ImportLinked
+		log.Printf("Linked %s to its card %q\n", issue.Key, card.Name)
+		return links.Put(Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: issue.Key, SyncState: LinkLinked})
+	}
+
+	desc := fmt.Sprintf("[%[1]s %[2]s](%[3]s/browse/%[2]s).\n\n", ":link:", issue.Key, xapOpenJira.Url)
+	card, err := xapTrello.AddCard(listId, item.CardName, desc)
+	if err != nil {
+		return err
+	}
+	item.CardId, item.CardUrl, item.Action = card.Id, card.Url, ImportCreated
+	err = links.Put(Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: issue.Key, SyncState: LinkImported, Imported: true})
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %s -> %q %s\n", issue.Key, card.Name, card.Url)
+	return xapOpenJira.AttachIssueToTrelloCard(issue.Key, card.Url)
+}
+
+func (r *ImportReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Import of %q into list %q at %s\n", r.Jql, r.List, r.Time.Format("2006-01-02 15:04"))
+	for _, item := range r.Items {
+		fmt.Fprintf(w, "  %-9s %-12s %s %s\n", item.Action, item.IssueKey, item.CardName, item.Error)
+	}
+}
@@ -0,0 +1,53 @@
+package xap_trello
+
+import "testing"
+
+func importActions(t *testing.T, jql string) map[string]ImportItem {
+	report, err := ImportIssues(jql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := map[string]ImportItem{}
+	for _, item := range report.Items {
+		items[item.IssueKey] = item
+	}
+	return items
+}
+
+func TestImportUpdatesOnlyTheCardsItCreated(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	items := importActions(t, "Sprint = 11")
+	// the Trello Card field of GS-101 names a card of the board
+	if item := items["GS-101"]; item.Action != ImportLinked || item.CardId != "5800000000000000000000d1" {
+		t.Errorf("GS-101 is %+v, expected to be linked to its card", item)
+	}
+	if item := items["GS-102"]; item.Action != ImportCreated {
+		t.Fatalf("GS-102 is %+v, expected a new card", item)
+	}
+
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cardId := range []string{items["GS-101"].CardId, items["GS-102"].CardId} {
+		if err := xapTrello.SetCardName(cardId, "renamed on the board"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items = importActions(t, "Sprint = 11")
+	if item := items["GS-101"]; item.Action != ImportUnchanged {
+		t.Errorf("GS-101 is %+v, the card it was linked to is not renamed", item)
+	}
+	if item := items["GS-102"]; item.Action != ImportUpdated {
+		t.Errorf("GS-102 is %+v, expected the imported card to be renamed", item)
+	}
+	card, err := xapTrello.Card(items["GS-101"].CardId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Name != "renamed on the board" {
+		t.Errorf("the card of GS-101 was renamed to %q", card.Name)
+	}
+}
@@ -1,9 +1,15 @@
 package xap_trello
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/barakb/go-jira"
-	"os"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
 	"fmt"
+	"strconv"
 	"strings"
 	"regexp"
 	"log"
@@ -15,25 +21,25 @@ type Jira struct {
 	IssueTypes   map[string]jira.IssueType
 	Url          string
 	MainScrumBoardId int
+	// the authorized client of Client, for the requests go-jira can not send
+	httpClient *http.Client
 }
 
-func create(url string) (*Jira, error) {
-	jiraClient, err := jira.NewClient(nil, url)
+func create(config JiraConfig) (*Jira, error) {
+	auth, err := NewJiraAuth(config.Url, config.Auth)
 	if err != nil {
 		return nil, err
 	}
-	res, err := jiraClient.Authentication.AcquireSessionCookie(os.Getenv("JIRA_USER"), os.Getenv("JIRA_PASSWORD"))
+	httpClient := &http.Client{Transport: auth.Transport(http.DefaultTransport)}
+	jiraClient, err := jira.NewClient(httpClient, config.Url)
 	if err != nil {
 		return nil, err
 	}
-	if res == false {
-		return nil, fmt.Errorf("Fail to autenticate user %s\n", os.Getenv("JIRA_USER"))
-	}
-	return &Jira{Client : jiraClient, Url: url}, nil
+	return &Jira{Client : jiraClient, Url: strings.TrimRight(config.Url, "/"), httpClient: httpClient}, nil
 }
 
 func CreateXAPJiraOpen() (*Jira, error) {
-	j, err := create("https://insightedge.atlassian.net")
+	j, err := create(jiraConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -99,15 +105,16 @@ func (j Jira) GetAllSprintIssues(sprintId int) ([]jira.Issue, error) {
 	return issues, err
 }
 
-func (j Jira) CreateFeature(name, desc, cardUrl string) (string, error) {
-	return j.createXAPIssue(name, desc, j.IssueTypes["New Feature"].ID, "Feature", cardUrl)
-}
-
-func (j Jira) CreateBug(name, desc, cardUrl string) (string, error) {
-	return j.createXAPIssue(name, desc, j.IssueTypes["Bug"].ID, "BUG", cardUrl)
+// CreateIssue creates an issue of the named type in the GS project.
+func (j Jira) CreateIssue(issueType, name, desc, cardUrl string) (string, error) {
+	t, ok := j.IssueTypes[issueType]
+	if !ok {
+		return "", fmt.Errorf("no issue type %q in project GS", issueType)
+	}
+	return j.createXAPIssue(name, desc, t.ID, cardUrl)
 }
 
-func (j Jira) createXAPIssue(name, desc, issueTypeId, issueTypeName, cardUrl string) (string, error) {
+func (j Jira) createXAPIssue(name, desc, issueTypeId, cardUrl string) (string, error) {
 	var summary = desc
 	if summary == "" {
 		summary = name
@@ -120,25 +127,27 @@ func (j Jira) createXAPIssue(name, desc, issueTypeId, issueTypeName, cardUrl str
 	name = strings.TrimLeft(regexp.MustCompile("\\([0-9.]+\\)").ReplaceAllLiteralString(name, ""), " ")
 	name = strings.TrimLeft(regexp.MustCompile("\\{[0-9.]+\\}").ReplaceAllLiteralString(name, ""), " ")
 	name = strings.TrimSpace(name)
-	i := jira.Issue{
-		Fields: &jira.IssueFields{
-			Type: jira.IssueType{
-				ID: issueTypeId,
-			},
-			Project: jira.Project{
-				Key: "GS",
-			},
-			Summary: name,
-			Description: summary,
-		},
+	fields := map[string]interface{}{
+		"issuetype":   map[string]string{"id": issueTypeId},
+		"project":     map[string]string{"key": "GS"},
+		"summary":     name,
+		"description": summary,
 	}
-	issue, _, err := j.Client.Issue.Create(&i)
-	if err != nil {
+	if cardUrl != "" {
+		// in the create request, an issue is never left without its card
+		fieldId, err := j.FieldId("Trello Card")
+		if err != nil {
+			return "", err
+		}
+		fields[fieldId] = cardUrl
+	}
+	issue := struct {
+		Key string `json:"key"`
+	}{}
+	if err := j.do("POST", "rest/api/2/issue", map[string]interface{}{"fields": fields}, &issue); err != nil {
 		return "", err
-
 	}
-	err = j.AttachIssueToTrelloCard(issue.Key, cardUrl)
-	return issue.Key, err
+	return issue.Key, nil
 }
 
 func (j Jira) AttachIssueToTrelloCard(key, url string) error {
@@ -160,3 +169,335 @@ func (j Jira) AddToSprint(issueKey string, activeSprintId int) error {
 	return err
 }
 
+// JiraComment is a comment as returned by rest/api/2/issue/{key}/comment
+type JiraComment struct {
+	Id     string `json:"id"`
+	Body   string `json:"body"`
+	Author struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"author"`
+	Created string `json:"created"`
+}
+
+type JiraAttachment struct {
+	Id       string `json:"id"`
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// do sends a raw REST request for the endpoints the go-jira client does not cover
+func (j Jira) do(method, path string, body interface{}, v interface{}) error {
+	req, err := j.Client.NewRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+	_, err = j.Client.Do(req, v)
+	return err
+}
+
+func (j Jira) Comments(key string) ([]JiraComment, error) {
+	res := struct {
+		Comments []JiraComment `json:"comments"`
+	}{}
+	err := j.do("GET", fmt.Sprintf("rest/api/2/issue/%s/comment?maxResults=1000", key), nil, &res)
+	return res.Comments, err
+}
+
+func (j Jira) AddComment(key, body string) error {
+	return j.do("POST", fmt.Sprintf("rest/api/2/issue/%s/comment", key), map[string]string{"body": body}, nil)
+}
+
+func (j Jira) Attachments(key string) ([]JiraAttachment, error) {
+	res := struct {
+		Fields struct {
+			Attachment []JiraAttachment `json:"attachment"`
+		} `json:"fields"`
+	}{}
+	err := j.do("GET", fmt.Sprintf("rest/api/2/issue/%s?fields=attachment", key), nil, &res)
+	return res.Fields.Attachment, err
+}
+
+// AddRemoteLink adds a web link to the issue, Jira updates the existing link when globalId is already known
+func (j Jira) AddRemoteLink(key, globalId, title, url string) error {
+	link := map[string]interface{}{
+		"globalId": globalId,
+		"object": map[string]string{
+			"url":   url,
+			"title": title,
+		},
+	}
+	return j.do("POST", fmt.Sprintf("rest/api/2/issue/%s/remotelink", key), link, nil)
+}
+
+// ApplyLabelMapping sets the epic link, components and labels mapped from the card labels, values
+// that belong to mappings of labels the card no longer has are removed. When labels map to different
+// epics the first label of the card wins.
+func (j Jira) ApplyLabelMapping(key string, cardLabels []string, mappings map[string]LabelMapping) error {
+	active := map[string]bool{}
+	addComponents, addLabels := map[string]bool{}, map[string]bool{}
+	epic := ""
+	for _, label := range cardLabels {
+		mapping, ok := mappings[label]
+		if !ok || active[label] {
+			continue
+		}
+		active[label] = true
+		for _, component := range mapping.Components {
+			addComponents[component] = true
+		}
+		for _, l := range mapping.Labels {
+			addLabels[l] = true
+		}
+		if mapping.EpicLink == "" || mapping.EpicLink == epic {
+			continue
+		}
+		if epic == "" {
+			epic = mapping.EpicLink
+		} else {
+			log.Printf("Label %q of %s maps to epic %s, keeping epic %s of a previous label\n", label, key, mapping.EpicLink, epic)
+		}
+	}
+	components, labels := []map[string]interface{}{}, []map[string]interface{}{}
+	staleEpics := map[string]bool{}
+	for label, mapping := range mappings {
+		if active[label] {
+			continue
+		}
+		for _, component := range mapping.Components {
+			if !addComponents[component] {
+				components = append(components, map[string]interface{}{"remove": map[string]string{"name": component}})
+			}
+		}
+		for _, l := range mapping.Labels {
+			if !addLabels[l] {
+				labels = append(labels, map[string]interface{}{"remove": l})
+			}
+		}
+		if mapping.EpicLink != "" {
+			staleEpics[mapping.EpicLink] = true
+		}
+	}
+	for component := range addComponents {
+		components = append(components, map[string]interface{}{"add": map[string]string{"name": component}})
+	}
+	for l := range addLabels {
+		labels = append(labels, map[string]interface{}{"add": l})
+	}
+
+	update := map[string]interface{}{}
+	if len(components) != 0 {
+		update["components"] = components
+	}
+	if len(labels) != 0 {
+		update["labels"] = labels
+	}
+	fields := map[string]interface{}{}
+	if epic != "" || len(staleEpics) != 0 {
+		epicFieldId, err := j.Client.Issue.GetCustomFieldId(key, "Epic Link")
+		if err != nil {
+			return err
+		}
+		if epic != "" {
+			fields[epicFieldId] = epic
+		} else {
+			// only clear an epic link that was set by a mapping
+			current := struct {
+				Fields map[string]interface{} `json:"fields"`
+			}{}
+			if err := j.do("GET", fmt.Sprintf("rest/api/2/issue/%s?fields=%s", key, epicFieldId), nil, &current); err != nil {
+				return err
+			}
+			if currentEpic, ok := current.Fields[epicFieldId].(string); ok && staleEpics[currentEpic] {
+				fields[epicFieldId] = nil
+			}
+		}
+	}
+	if len(update) == 0 && len(fields) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"update": update, "fields": fields}
+	return j.do("PUT", fmt.Sprintf("rest/api/2/issue/%s", key), body, nil)
+}
+
+// IssueSummary is the part of an issue needed to compare it with its card.
+type IssueSummary struct {
+	Key            string  `json:"key"`
+	Summary        string  `json:"summary"`
+	Status         string  `json:"status"`
+	StatusCategory string  `json:"status_category"`
+	Points         float64 `json:"points"`
+}
+
+// FieldId returns the id of the field with the given name, custom fields have ids like customfield_10004.
+func (j Jira) FieldId(name string) (string, error) {
+	ids, err := j.fieldIds()
+	if err != nil {
+		return "", err
+	}
+	if id, ok := ids[name]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("no jira field named %q", name)
+}
+
+// fieldIds maps the names of the fields to their ids.
+func (j Jira) fieldIds() (map[string]string, error) {
+	fields := []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}{}
+	if err := j.do("GET", "rest/api/2/field", nil, &fields); err != nil {
+		return nil, err
+	}
+	ids := map[string]string{}
+	for _, field := range fields {
+		ids[field.Name] = field.Id
+	}
+	return ids, nil
+}
+
+// SetFields sets the fields of the issue, keyed by field name or id.
+func (j Jira) SetFields(key string, fields map[string]interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	ids, err := j.fieldIds()
+	if err != nil {
+		return err
+	}
+	byId := map[string]interface{}{}
+	for name, value := range fields {
+		if id, ok := ids[name]; ok {
+			name = id
+		}
+		byId[name] = value
+	}
+	return j.do("PUT", "rest/api/2/issue/"+key, map[string]interface{}{"fields": byId}, nil)
+}
+
+// SearchSummaries runs the jql query and returns the key, summary, status and story points of each issue.
+func (j Jira) SearchSummaries(jql string) ([]IssueSummary, error) {
+	pointsField, err := j.FieldId("Story Points")
+	if err != nil {
+		return nil, err
+	}
+	res := []IssueSummary{}
+	for startAt := 0; ; {
+		page := struct {
+			Total  int `json:"total"`
+			Issues []struct {
+				Key    string                     `json:"key"`
+				Fields map[string]json.RawMessage `json:"fields"`
+			} `json:"issues"`
+		}{}
+		query := url.Values{
+			"jql":        {jql},
+			"fields":     {"summary,status," + pointsField},
+			"startAt":    {strconv.Itoa(startAt)},
+			"maxResults": {"100"},
+		}
+		if err := j.do("GET", "rest/api/2/search?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		for _, issue := range page.Issues {
+			summary := IssueSummary{Key: issue.Key}
+			status := struct {
+				Name           string `json:"name"`
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			}{}
+			json.Unmarshal(issue.Fields["summary"], &summary.Summary)
+			json.Unmarshal(issue.Fields["status"], &status)
+			json.Unmarshal(issue.Fields[pointsField], &summary.Points)
+			summary.Status, summary.StatusCategory = status.Name, status.StatusCategory.Key
+			res = append(res, summary)
+		}
+		startAt += len(page.Issues)
+		if len(page.Issues) == 0 || page.Total <= startAt {
+			return res, nil
+		}
+	}
+}
+
+// FindIssueByCard returns the key of the issue whose Trello Card field is cardUrl, or "" when there is none.
+func (j Jira) FindIssueByCard(cardUrl string) (string, error) {
+	fieldId, err := j.FieldId("Trello Card")
+	if err != nil {
+		return "", err
+	}
+	res := struct {
+		Issues []struct {
+			Key    string                     `json:"key"`
+			Fields map[string]json.RawMessage `json:"fields"`
+		} `json:"issues"`
+	}{}
+	query := url.Values{
+		"jql":    {fmt.Sprintf(`"Trello Card" ~ "%s"`, cardUrl)},
+		"fields": {fieldId},
+	}
+	if err := j.do("GET", "rest/api/2/search?"+query.Encode(), nil, &res); err != nil {
+		return "", err
+	}
+	// ~ is a text search, keep only the exact match
+	for _, issue := range res.Issues {
+		value := ""
+		json.Unmarshal(issue.Fields[fieldId], &value)
+		if value == cardUrl {
+			return issue.Key, nil
+		}
+	}
+	return "", nil
+}
+
+// TrelloCard returns the Trello Card field of the issue, "" when it is not set.
+func (j Jira) TrelloCard(key string) (string, error) {
+	fieldId, err := j.FieldId("Trello Card")
+	if err != nil {
+		return "", err
+	}
+	res := struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}{}
+	if err := j.do("GET", "rest/api/2/issue/"+key+"?fields="+fieldId, nil, &res); err != nil {
+		return "", err
+	}
+	value := ""
+	json.Unmarshal(res.Fields[fieldId], &value)
+	return value, nil
+}
+
+// Attach uploads a file to the issue.
+func (j Jira) Attach(key, filename, contentType string, content []byte) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/rest/api/2/issue/%s/attachments", j.Url, key), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fail to attach %s to %s, status %s", filename, key, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package xap_trello
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JiraAuthSession = "session" // JIRA_USER/JIRA_PASSWORD login, Jira Server only
+	JiraAuthBasic   = "basic"   // user email and API token, Atlassian Cloud
+	JiraAuthPAT     = "pat"     // personal access token, Jira Data Center
+	JiraAuthOAuth1  = "oauth1"  // application link with an RSA key, Jira Server and Data Center
+	JiraAuthOAuth2  = "oauth2"  // OAuth 2.0 (3LO), Atlassian Cloud
+)
+
+// JiraAuthConfig selects how to authenticate to Jira, every credential may be a secret reference
+// (env:NAME or file:/path), see ResolveSecret.
+type JiraAuthConfig struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// the API token of basic auth or the personal access token
+	Token string `json:"token"`
+	// OAuth 1.0a
+	ConsumerKey    string `json:"consumer_key"`
+	PrivateKeyFile string `json:"private_key_file"`
+	AccessToken    string `json:"access_token"`
+	// OAuth 2.0, the jira url is then https://api.atlassian.com/ex/jira/<cloud id>
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+	// Atlassian rotates the refresh token, the current one is stored in JIRA_TOKEN_FILE_NAME encrypted with this secret reference
+	TokenKey string `json:"token_key"`
+}
+
+// the refreshed OAuth 2.0 token, the configured refresh token is only used until the first refresh
+const JIRA_TOKEN_FILE_NAME = "jira-token.json"
+
+// JiraAuth adds the credentials to the requests of the Jira client.
+type JiraAuth interface {
+	Transport(base http.RoundTripper) http.RoundTripper
+}
+
+// NewJiraAuth creates the auth strategy of the config, without a type the JIRA_USER together with
+// JIRA_TOKEN or JIRA_PASSWORD environment variables are used.
+func NewJiraAuth(jiraUrl string, config JiraAuthConfig) (JiraAuth, error) {
+	if config.Type == "" {
+		config.User = os.Getenv("JIRA_USER")
+		if token := os.Getenv("JIRA_TOKEN"); token != "" {
+			config.Type, config.Token = JiraAuthBasic, token
+		} else {
+			config.Type, config.Password = JiraAuthSession, os.Getenv("JIRA_PASSWORD")
+		}
+	}
+	user, err := ResolveSecret(config.User)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case JiraAuthSession:
+		password, err := ResolveSecret(config.Password)
+		if err != nil {
+			return nil, err
+		}
+		return &sessionAuth{url: strings.TrimRight(jiraUrl, "/"), user: user, password: password}, nil
+	case JiraAuthBasic:
+		token, err := ResolveSecret(config.Token)
+		if err != nil {
+			return nil, err
+		}
+		return basicAuth{user: user, token: token}, nil
+	case JiraAuthPAT:
+		token, err := ResolveSecret(config.Token)
+		if err != nil {
+			return nil, err
+		}
+		return bearerAuth{token: token}, nil
+	case JiraAuthOAuth1:
+		return newOAuth1Auth(config)
+	case JiraAuthOAuth2:
+		return newOAuth2Auth(config)
+	}
+	return nil, fmt.Errorf("unknown jira auth type %q", config.Type)
+}
+
+type authTransport struct {
+	base      http.RoundTripper
+	authorize func(req *http.Request) error
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// requests may not be modified by a transport
+	clone := *req
+	clone.Header = http.Header{}
+	for k, v := range req.Header {
+		clone.Header[k] = v
+	}
+	if err := t.authorize(&clone); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(&clone)
+}
+
+type basicAuth struct {
+	user, token string
+}
+
+func (a basicAuth) Transport(base http.RoundTripper) http.RoundTripper {
+	return authTransport{base: base, authorize: func(req *http.Request) error {
+		req.SetBasicAuth(a.user, a.token)
+		return nil
+	}}
+}
+
+type bearerAuth struct {
+	token string
+}
+
+func (a bearerAuth) Transport(base http.RoundTripper) http.RoundTripper {
+	return authTransport{base: base, authorize: func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+		return nil
+	}}
+}
+
+// sessionAuth logs in with rest/auth/1/session and logs in again when the session expires.
+type sessionAuth struct {
+	url, user, password string
+	sync.Mutex
+	cookies []*http.Cookie
+}
+
+func (a *sessionAuth) Transport(base http.RoundTripper) http.RoundTripper {
+	return &sessionTransport{auth: a, base: base}
+}
+
+func (a *sessionAuth) login(base http.RoundTripper) error {
+	body, err := json.Marshal(map[string]string{"username": a.user, "password": a.password})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.url+"/rest/auth/1/session", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fail to autenticate user %s, status %s", a.user, resp.Status)
+	}
+	a.cookies = resp.Cookies()
+	return nil
+}
+
+type sessionTransport struct {
+	auth *sessionAuth
+	base http.RoundTripper
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// keep the body so the request can be sent again after a new login
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	send := func(cookies []*http.Cookie) (*http.Response, error) {
+		clone := *req
+		clone.Header = http.Header{}
+		for k, v := range req.Header {
+			clone.Header[k] = v
+		}
+		for _, cookie := range cookies {
+			clone.AddCookie(cookie)
+		}
+		if body != nil {
+			clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		return t.base.RoundTrip(&clone)
+	}
+
+	t.auth.Lock()
+	if t.auth.cookies == nil {
+		if err := t.auth.login(t.base); err != nil {
+			t.auth.Unlock()
+			return nil, err
+		}
+	}
+	cookies := t.auth.cookies
+	t.auth.Unlock()
+
+	resp, err := send(cookies)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	log.Printf("Jira session of %s expired, logging in again\n", t.auth.user)
+	t.auth.Lock()
+	err = t.auth.login(t.base)
+	cookies = t.auth.cookies
+	t.auth.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return send(cookies)
+}
+
+// oauth1Auth signs the requests with RSA-SHA1 as Jira application links expect.
+type oauth1Auth struct {
+	consumerKey, accessToken string
+	key                      *rsa.PrivateKey
+}
+
+func newOAuth1Auth(config JiraAuthConfig) (JiraAuth, error) {
+	accessToken, err := ResolveSecret(config.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := ResolveSecret(config.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("the key in %s is not an RSA key", keyFile)
+		}
+	}
+	return oauth1Auth{consumerKey: config.ConsumerKey, accessToken: accessToken, key: key}, nil
+}
+
+func (a oauth1Auth) Transport(base http.RoundTripper) http.RoundTripper {
+	return authTransport{base: base, authorize: a.sign}
+}
+
+func (a oauth1Auth) sign(req *http.Request) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     a.consumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            a.accessToken,
+		"oauth_version":          "1.0",
+	}
+	params := []string{}
+	for k, v := range oauthParams {
+		params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+	}
+	for k, values := range req.URL.Query() {
+		for _, v := range values {
+			params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+		}
+	}
+	sort.Strings(params)
+	baseUrl := fmt.Sprintf("%s://%s%s", strings.ToLower(req.URL.Scheme), strings.ToLower(req.URL.Host), req.URL.EscapedPath())
+	baseString := strings.Join([]string{req.Method, oauthEscape(baseUrl), oauthEscape(strings.Join(params, "&"))}, "&")
+
+	hashed := sha1.Sum([]byte(baseString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA1, hashed[:])
+	if err != nil {
+		return err
+	}
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(signature)
+	header := []string{}
+	for k, v := range oauthParams {
+		header = append(header, fmt.Sprintf("%s=%q", k, oauthEscape(v)))
+	}
+	sort.Strings(header)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	return nil
+}
+
+// oauthEscape percent encodes as RFC 3986 requires, url.QueryEscape encodes spaces as + and escapes ~.
+func oauthEscape(s string) string {
+	return strings.Replace(strings.Replace(url.QueryEscape(s), "+", "%20", -1), "%7E", "~", -1)
+}
+
+var atlassianEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://auth.atlassian.com/authorize",
+	TokenURL: "https://auth.atlassian.com/oauth/token",
+}
+
+// oauth2Auth uses the refresh token of an authorized 3LO app, access tokens are refreshed when they expire
+// and each refreshed token, with the rotated refresh token, is stored for the next start.
+type oauth2Auth struct {
+	source oauth2.TokenSource
+}
+
+func newOAuth2Auth(config JiraAuthConfig) (JiraAuth, error) {
+	clientSecret, err := ResolveSecret(config.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := ResolveSecret(config.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tokenCipher(config.TokenKey, "jira.auth.token_key"); err != nil {
+		return nil, err
+	}
+	oauthConf := &oauth2.Config{
+		ClientID:     config.ClientId,
+		ClientSecret: clientSecret,
+		Endpoint:     atlassianEndpoint,
+		Scopes:       []string{"read:jira-work", "write:jira-work", "offline_access"},
+	}
+	// a new refresh token in the config replaces the stored one
+	hash := sha256.Sum256([]byte(refreshToken))
+	origin := hex.EncodeToString(hash[:])
+	token, err := readJiraToken(config.TokenKey, origin)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		token = &oauth2.Token{RefreshToken: refreshToken}
+	}
+	save := func(token *oauth2.Token) error {
+		return saveEncryptedToken(JIRA_TOKEN_FILE_NAME, config.TokenKey, "jira.auth.token_key", origin, token)
+	}
+	source := &storingTokenSource{base: oauthConf.TokenSource(context.Background(), token), last: token, name: "Jira", save: save}
+	return oauth2Auth{source: oauth2.ReuseTokenSource(token, source)}, nil
+}
+
+// readJiraToken returns the stored token refreshed from the configured refresh token of origin, nil when
+// there is none.
+func readJiraToken(keyRef, origin string) (*oauth2.Token, error) {
+	sealed := encryptedToken{}
+	if err := FromJSONFile(&sealed, JIRA_TOKEN_FILE_NAME); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if sealed.Origin != origin {
+		log.Printf("The jira refresh token was reconfigured, ignoring %s\n", JIRA_TOKEN_FILE_NAME)
+		return nil, nil
+	}
+	token, _, err := openEncryptedToken(JIRA_TOKEN_FILE_NAME, keyRef, "jira.auth.token_key", sealed)
+	return token, err
+}
+
+func (a oauth2Auth) Transport(base http.RoundTripper) http.RoundTripper {
+	return &oauth2.Transport{Source: a.source, Base: base}
+}
@@ -0,0 +1,203 @@
+package xap_trello
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOAuth2StoresTheRotatedRefreshToken(t *testing.T) {
+	defer inTempDir(t)()
+	refreshed := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			r.ParseForm()
+			refreshed = append(refreshed, r.Form.Get("refresh_token"))
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"access-%[1]d","refresh_token":"refresh-%[1]d","token_type":"bearer","expires_in":1}`, len(refreshed))
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+	endpoint := atlassianEndpoint
+	defer func() { atlassianEndpoint = endpoint }()
+	atlassianEndpoint.TokenURL = server.URL + "/oauth/token"
+
+	config := JiraAuthConfig{Type: JiraAuthOAuth2, ClientId: "client", ClientSecret: "secret", RefreshToken: "refresh-0", TokenKey: "key"}
+	// every call is a restart of the server
+	authorization := func() string {
+		auth, err := NewJiraAuth(server.URL, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: auth.Transport(http.DefaultTransport)}).Get(server.URL + "/rest/api/2/myself")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	if got := authorization(); got != "Bearer access-1" {
+		t.Errorf("authorization is %q, expected the refreshed token", got)
+	}
+	// the token expired, it is refreshed with the rotated refresh token
+	if got := authorization(); got != "Bearer access-2" {
+		t.Errorf("authorization after a restart is %q, expected a token refreshed from the stored one", got)
+	}
+	config.RefreshToken = "reconfigured"
+	authorization()
+	if expected := fmt.Sprint([]string{"refresh-0", "refresh-1", "reconfigured"}); fmt.Sprint(refreshed) != expected {
+		t.Errorf("refreshed %v, expected %s", refreshed, expected)
+	}
+
+	config.TokenKey = ""
+	if _, err := NewJiraAuth(server.URL, config); err == nil {
+		t.Errorf("expected an error without a token key")
+	}
+}
+
+func TestSessionLogsInAgainWhenTheSessionExpires(t *testing.T) {
+	logins, session := 0, ""
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/auth/1/session" {
+			logins++
+			session = fmt.Sprintf("session-%d", logins)
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: session})
+			return
+		}
+		if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != session {
+			http.Error(w, "session expired", http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	auth, err := NewJiraAuth(server.URL, JiraAuthConfig{Type: JiraAuthSession, User: "dev", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: auth.Transport(http.DefaultTransport)}
+	post := func(body string) int {
+		resp, err := client.Post(server.URL+"/rest/api/2/issue/GS-101/comment", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := post(`{"body":"first"}`); status != http.StatusOK || logins != 1 {
+		t.Errorf("the first request is answered %d after %d login(s), expected one login", status, logins)
+	}
+	// the server drops the session
+	session = "expired"
+	if status := post(`{"body":"second"}`); status != http.StatusOK || logins != 2 {
+		t.Errorf("the request after the session expired is answered %d after %d login(s), expected a second login", status, logins)
+	}
+	if expected := fmt.Sprint([]string{`{"body":"first"}`, `{"body":"second"}`}); fmt.Sprint(bodies) != expected {
+		t.Errorf("the server received %v, expected the body sent again after the login", bodies)
+	}
+}
+
+func TestOAuth1SignsTheRequests(t *testing.T) {
+	defer inTempDir(t)()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile("jira.pem", pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth ")
+		oauthParams := map[string]string{}
+		for _, param := range strings.Split(header, ", ") {
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) != 2 {
+				http.Error(w, "bad authorization "+header, http.StatusUnauthorized)
+				return
+			}
+			value, err := url.QueryUnescape(strings.Trim(parts[1], `"`))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			oauthParams[parts[0]] = value
+		}
+		if oauthParams["oauth_consumer_key"] != "xap-trello" || oauthParams["oauth_token"] != "access" {
+			http.Error(w, "unknown consumer or token", http.StatusUnauthorized)
+			return
+		}
+		params := []string{}
+		for k, v := range oauthParams {
+			if k != "oauth_signature" {
+				params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+			}
+		}
+		for k, values := range r.URL.Query() {
+			for _, v := range values {
+				params = append(params, oauthEscape(k)+"="+oauthEscape(v))
+			}
+		}
+		sort.Strings(params)
+		baseString := strings.Join([]string{r.Method, oauthEscape("http://" + r.Host + r.URL.EscapedPath()), oauthEscape(strings.Join(params, "&"))}, "&")
+		signature, err := base64.StdEncoding.DecodeString(oauthParams["oauth_signature"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		hashed := sha1.Sum([]byte(baseString))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hashed[:], signature); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		verified++
+	}))
+	defer server.Close()
+
+	config := JiraAuthConfig{Type: JiraAuthOAuth1, ConsumerKey: "xap-trello", PrivateKeyFile: "jira.pem", AccessToken: "access"}
+	auth, err := NewJiraAuth(server.URL, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: auth.Transport(http.DefaultTransport)}
+	// the query is part of the signature, with the characters that are escaped differently
+	for _, path := range []string{"/rest/api/2/myself", "/rest/api/2/search?jql=" + url.QueryEscape(`project = GS AND text ~ "a+b~c"`)} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s is answered %d: %s", path, resp.StatusCode, body)
+		}
+	}
+	if verified != 2 {
+		t.Errorf("%d request(s) had a valid signature, expected 2", verified)
+	}
+
+	config.PrivateKeyFile = "missing.pem"
+	if _, err := NewJiraAuth(server.URL, config); err == nil {
+		t.Errorf("expected an error without the private key")
+	}
+}
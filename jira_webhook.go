@@ -0,0 +1,205 @@
+package xap_trello
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/barakb/go-trello"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JiraWebhookEvent is the part of a Jira webhook payload that the sync uses.
+type JiraWebhookEvent struct {
+	WebhookEvent string `json:"webhookEvent"`
+	Issue        struct {
+		Key string `json:"key"`
+	} `json:"issue"`
+	Sprint struct {
+		Id        int    `json:"id"`
+		Name      string `json:"name"`
+		State     string `json:"state"`
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	} `json:"sprint"`
+}
+
+const DEFAULT_WEBHOOK_DELAY = 10 * time.Second
+
+// verifyJiraWebhook accepts the secret as the secret query parameter, for Jira Server that can not
+// sign, or as the X-Hub-Signature HMAC that Jira Cloud sends for webhooks with a secret.
+func verifyJiraWebhook(r *http.Request, body []byte, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("no jira webhook secret is configured")
+	}
+	if signature := r.Header.Get("X-Hub-Signature"); signature != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return fmt.Errorf("bad webhook signature")
+		}
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(secret)) != 1 {
+		return fmt.Errorf("bad webhook secret")
+	}
+	return nil
+}
+
+// WebhookQueue runs jobs one at a time once their key was quiet for the delay, so a burst of events
+// on the same issue ends in a single sync.
+type WebhookQueue struct {
+	sync.Mutex
+	delay  time.Duration
+	jobs   map[string]func()
+	timers map[string]*time.Timer
+	ready  chan string
+}
+
+func NewWebhookQueue(delay time.Duration) *WebhookQueue {
+	q := &WebhookQueue{delay: delay, jobs: map[string]func(){}, timers: map[string]*time.Timer{}, ready: make(chan string, 100)}
+	go q.work()
+	return q
+}
+
+// Add queues job under key, replacing the job already waiting under that key.
+func (q *WebhookQueue) Add(key string, job func()) {
+	q.Lock()
+	defer q.Unlock()
+	q.jobs[key] = job
+	if timer, ok := q.timers[key]; ok {
+		timer.Reset(q.delay)
+		return
+	}
+	q.timers[key] = time.AfterFunc(q.delay, func() {
+		q.ready <- key
+	})
+}
+
+func (q *WebhookQueue) work() {
+	for key := range q.ready {
+		q.Lock()
+		job := q.jobs[key]
+		delete(q.jobs, key)
+		delete(q.timers, key)
+		q.Unlock()
+		if job != nil {
+			job()
+		}
+	}
+}
+
+// HandleJiraEvent queues the work of a Jira webhook event.
+func HandleJiraEvent(queue *WebhookQueue, burndown *Burndown, event JiraWebhookEvent) {
+	switch {
+	case strings.HasPrefix(event.WebhookEvent, "sprint_"):
+		queue.Add(fmt.Sprintf("sprint:%d", event.Sprint.Id), func() {
+			if err := applySprintEvent(burndown, event); err != nil {
+				log.Printf("Failed to apply %s of sprint %s, error is: %s\n", event.WebhookEvent, event.Sprint.Name, err.Error())
+			}
+		})
+	case event.Issue.Key != "":
+		key := event.Issue.Key
+		queue.Add("issue:"+key, func() {
+			if err := SyncIssue(key); err != nil {
+				log.Printf("Failed to sync issue %s, error is: %s\n", key, err.Error())
+			}
+		})
+	default:
+		log.Printf("Ignoring jira webhook event %q\n", event.WebhookEvent)
+	}
+}
+
+// SyncIssue syncs a single linked issue and card pair the way Trello2Jira syncs every card.
+func SyncIssue(key string) error {
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return err
+	}
+	link, ok := links.ByIssue(key)
+	if !ok {
+		log.Printf("Issue %s is not linked to a trello card, nothing to sync\n", key)
+		return nil
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return err
+	}
+	xapOpenJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		return err
+	}
+	card, err := xapTrello.Card(link.CardId)
+	if err != nil {
+		links.SetSyncState(link.CardId, err)
+		return err
+	}
+	log.Printf("Syncing issue %s with card %q\n", key, card.Name)
+	config := ReadConfig()
+	if len(config.LabelMappings) != 0 {
+		err = xapOpenJira.ApplyLabelMapping(key, cardLabels(card), config.LabelMappings)
+	}
+	MirrorDiscussion(xapTrello, xapOpenJira, map[string]trello.Card{key: card})
+	return links.SetSyncState(card.Id, err)
+}
+
+// applySprintEvent keeps the burndown sprint in line with the active Jira sprint. With the file
+// sprint source the sprint is written to sprint.json, the burndown then follows its source. Events
+// of a sprint that a rollover is moving to are left to the rollover.
+func applySprintEvent(burndown *Burndown, event JiraWebhookEvent) error {
+	if event.WebhookEvent != "sprint_started" && event.WebhookEvent != "sprint_updated" {
+		log.Printf("Jira %s of sprint %s\n", event.WebhookEvent, event.Sprint.Name)
+		return nil
+	}
+	if event.Sprint.State != "active" {
+		return nil
+	}
+	rollover, err := LastRollover()
+	if err != nil {
+		return err
+	}
+	if rollover != nil && rollover.Name == event.Sprint.Name && !rollover.Done() {
+		log.Printf("Sprint %s is handled by its rollover\n", event.Sprint.Name)
+		return nil
+	}
+	source, err := NewSprintSource(ReadConfig().Sprint)
+	if err != nil {
+		return err
+	}
+	if fileSource, ok := source.(FileSprintSource); ok {
+		start, err := parseJiraTime(event.Sprint.StartDate)
+		if err != nil {
+			return err
+		}
+		end, err := parseJiraTime(event.Sprint.EndDate)
+		if err != nil {
+			return err
+		}
+		sprint := &Sprint{Name: event.Sprint.Name, Start: start, End: end}
+		if current, _ := fileSource.ActiveSprint(); sprint.sameAs(current) {
+			return nil
+		}
+		log.Printf("Burndown sprint is now %+v\n", *sprint)
+		if err := writeSprint(*sprint, fileSource.Path); err != nil {
+			return err
+		}
+	}
+	if burndown == nil {
+		return nil
+	}
+	return burndown.checkSprint()
+}
+
+func parseJiraTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Parse("2006-01-02T15:04:05.000-0700", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,98 @@
+package xap_trello
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyJiraWebhook(t *testing.T) {
+	body := []byte(`{"webhookEvent":"jira:issue_updated","issue":{"key":"GS-101"}}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	signed := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	cases := []struct {
+		name, url, signature, secret string
+		ok                           bool
+	}{
+		{"signed", "/api/webhooks/jira", signed, "s3cret", true},
+		{"bad signature", "/api/webhooks/jira", "sha256=00", "s3cret", false},
+		{"signed with another secret", "/api/webhooks/jira", signed, "other", false},
+		{"query secret", "/api/webhooks/jira?secret=s3cret", "", "s3cret", true},
+		{"bad query secret", "/api/webhooks/jira?secret=guess", "", "s3cret", false},
+		{"bad signature with the query secret", "/api/webhooks/jira?secret=s3cret", "sha256=00", "s3cret", false},
+		{"missing", "/api/webhooks/jira", "", "s3cret", false},
+		{"not configured", "/api/webhooks/jira?secret=", "", "", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", c.url, bytes.NewReader(body))
+		if c.signature != "" {
+			r.Header.Set("X-Hub-Signature", c.signature)
+		}
+		if err := verifyJiraWebhook(r, body, c.secret); (err == nil) != c.ok {
+			t.Errorf("%s: verified is %t, expected %t (%v)", c.name, err == nil, c.ok, err)
+		}
+	}
+}
+
+func TestLoggerRedactsTheWebhookSecret(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+
+	handler := Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "JIRA_WEBHOOK")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/webhooks/jira?user_id=admin&secret=s3cret", nil))
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("the secret is logged: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "/api/webhooks/jira?") || !strings.Contains(out.String(), "user_id=admin") {
+		t.Errorf("the request is not logged: %s", out.String())
+	}
+}
+
+func TestWebhookQueueCoalescesABurst(t *testing.T) {
+	queue := NewWebhookQueue(50 * time.Millisecond)
+	lock := sync.Mutex{}
+	runs := map[string][]int{}
+	done := make(chan struct{}, 10)
+	job := func(key string, event int) func() {
+		return func() {
+			lock.Lock()
+			runs[key] = append(runs[key], event)
+			lock.Unlock()
+			done <- struct{}{}
+		}
+	}
+	for event := 1; event <= 5; event++ {
+		queue.Add("issue:GS-101", job("issue:GS-101", event))
+		time.Sleep(10 * time.Millisecond)
+	}
+	queue.Add("issue:GS-102", job("issue:GS-102", 1))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the queued jobs did not run")
+		}
+	}
+	// nothing else runs once the keys are quiet
+	time.Sleep(150 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if events := runs["issue:GS-101"]; len(events) != 1 || events[0] != 5 {
+		t.Errorf("the burst on GS-101 ran the jobs of the events %v, expected only the last one", events)
+	}
+	if events := runs["issue:GS-102"]; len(events) != 1 {
+		t.Errorf("GS-102 ran the jobs of the events %v, expected one", events)
+	}
+}
@@ -0,0 +1,150 @@
+package xap_trello
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const JOURNAL_FILE_NAME = "journal.log"
+
+const (
+	JournalStarted = "started"
+	JournalDone    = "done"
+)
+
+// The mutating steps of Trello2Jira.
+const (
+	OpCreateIssue   = "create-issue"
+	OpSetFields     = "set-fields"
+	OpAttachIssue   = "attach-issue"
+	OpSetDesc       = "set-desc"
+	OpMoveToSprint  = "move-to-sprint"
+	OpMoveToBacklog = "move-to-backlog"
+	OpApplyLabels   = "apply-labels"
+)
+
+type JournalEntry struct {
+	Op       string    `json:"op"`
+	Target   string    `json:"target"` // the card id, or the issue key for issue only steps
+	IssueKey string    `json:"issue_key,omitempty"`
+	State    string    `json:"state"`
+	Time     time.Time `json:"time"`
+}
+
+func (e JournalEntry) id() string {
+	return e.Op + ":" + e.Target
+}
+
+// Journal is an append only log of the steps of a sync, each step is written before it runs and
+// again once it is done, so a run that crashed leaves its half done steps behind.
+type Journal struct {
+	sync.Mutex
+	path    string
+	file    *os.File
+	pending map[string]JournalEntry
+}
+
+// OpenJournal opens the journal at path and holds an exclusive lock on it until Close, a second
+// sync started meanwhile waits for the first one to close its journal.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j := &Journal{path: path, file: f, pending: map[string]JournalEntry{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line cut by a crash
+			continue
+		}
+		if entry.State == JournalDone {
+			delete(j.pending, entry.id())
+		} else {
+			j.pending[entry.id()] = entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) write(entry JournalEntry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(bytes, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Begin(op, target, key string) error {
+	j.Lock()
+	defer j.Unlock()
+	entry := JournalEntry{Op: op, Target: target, IssueKey: key, State: JournalStarted, Time: time.Now()}
+	j.pending[entry.id()] = entry
+	return j.write(entry)
+}
+
+func (j *Journal) Done(op, target, key string) error {
+	j.Lock()
+	defer j.Unlock()
+	entry := JournalEntry{Op: op, Target: target, IssueKey: key, State: JournalDone, Time: time.Now()}
+	delete(j.pending, entry.id())
+	return j.write(entry)
+}
+
+// Step journals f, the step stays pending when f fails.
+func (j *Journal) Step(op, target, key string, f func() error) error {
+	if err := j.Begin(op, target, key); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return j.Done(op, target, key)
+}
+
+// Pending returns the steps that were started and never finished.
+func (j *Journal) Pending(op string) []JournalEntry {
+	j.Lock()
+	defer j.Unlock()
+	res := []JournalEntry{}
+	for _, entry := range j.pending {
+		if entry.Op == op {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// Close rewrites the journal with only the pending steps, closes it and releases its lock.
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	// still under the lock, the appends start over from the empty file
+	if err := j.file.Truncate(0); err != nil {
+		j.file.Close()
+		return err
+	}
+	for _, entry := range j.pending {
+		if err := j.write(entry); err != nil {
+			j.file.Close()
+			return err
+		}
+	}
+	return j.file.Close()
+}
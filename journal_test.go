@@ -0,0 +1,58 @@
+package xap_trello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJournalKeepsThePendingStepsOfOverlappingRuns(t *testing.T) {
+	defer inTempDir(t)()
+
+	first, err := OpenJournal(JOURNAL_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Begin(OpCreateIssue, "card-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan *Journal)
+	go func() {
+		second, err := OpenJournal(JOURNAL_FILE_NAME)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- second
+	}()
+	select {
+	case <-opened:
+		t.Fatal("a second journal was opened while the first run holds it")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := <-opened
+	if second == nil {
+		t.FailNow()
+	}
+	if pending := second.Pending(OpCreateIssue); len(pending) != 1 || pending[0].Target != "card-1" {
+		t.Errorf("the second run sees the pending steps %+v, expected the create of card-1", pending)
+	}
+	if err := second.Begin(OpSetDesc, "card-2", "GS-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := OpenJournal(JOURNAL_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if len(third.Pending(OpCreateIssue)) != 1 || len(third.Pending(OpSetDesc)) != 1 {
+		t.Errorf("the journal lost a pending step, create %+v, set-desc %+v", third.Pending(OpCreateIssue), third.Pending(OpSetDesc))
+	}
+}
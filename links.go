@@ -0,0 +1,230 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const LINKS_FILE_NAME = "links.json"
+
+const (
+	LinkCreated  = "created"  // the issue was created for the card
+	LinkLinked   = "linked"   // the card was linked to an existing issue
+	LinkImported = "imported" // the card was created for the issue
+	LinkSynced   = "synced"   // the last sync of the pair succeeded
+	LinkError    = "error"    // the last sync of the pair failed
+)
+
+// Link is a durable association of a Trello card and a Jira issue.
+type Link struct {
+	CardId    string    `json:"card_id"`
+	CardUrl   string    `json:"card_url"`
+	CardName  string    `json:"card_name"`
+	IssueKey  string    `json:"issue_key"`
+	Created   time.Time `json:"created"`
+	SyncState string    `json:"sync_state"`
+	LastSync  time.Time `json:"last_sync"`
+	LastError string    `json:"last_error,omitempty"`
+	// the card was created by the import, only these cards follow the summary of the issue
+	Imported bool `json:"imported,omitempty"`
+}
+
+// LinkRegistry keeps the links in a json file, the file is reloaded when another process or registry changes it.
+type LinkRegistry struct {
+	sync.RWMutex
+	path string
+	// the file last read or written, every save replaces the file
+	info  os.FileInfo
+	links map[string]*Link
+}
+
+var (
+	defaultLinks     *LinkRegistry
+	defaultLinksErr  error
+	defaultLinksOnce sync.Once
+)
+
+// DefaultLinkRegistry returns the registry stored at links.json, shared by everything in the process.
+func DefaultLinkRegistry() (*LinkRegistry, error) {
+	defaultLinksOnce.Do(func() {
+		defaultLinks, defaultLinksErr = OpenLinkRegistry(LINKS_FILE_NAME)
+	})
+	return defaultLinks, defaultLinksErr
+}
+
+func OpenLinkRegistry(path string) (*LinkRegistry, error) {
+	r := &LinkRegistry{path: path, links: map[string]*Link{}}
+	r.Lock()
+	defer r.Unlock()
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// refresh reloads the file if it changed since it was last read, must be called with the lock held.
+func (r *LinkRegistry) refresh() error {
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !r.changed(info) {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	links := []*Link{}
+	if err := json.Unmarshal(bytes, &links); err != nil {
+		return fmt.Errorf("fail to parse link registry %s: %s", r.path, err.Error())
+	}
+	r.links = map[string]*Link{}
+	for _, link := range links {
+		r.links[link.CardId] = link
+	}
+	r.info = info
+	return nil
+}
+
+// changed tells if info is another file than the one last read, the mtime alone may not tell two saves apart.
+func (r *LinkRegistry) changed(info os.FileInfo) bool {
+	return r.info == nil || !os.SameFile(r.info, info) || !info.ModTime().Equal(r.info.ModTime()) || info.Size() != r.info.Size()
+}
+
+func (r *LinkRegistry) save() error {
+	bytes, err := json.MarshalIndent(r.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	// write and rename so a crash never leaves a truncated registry behind
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), ".links")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(r.path); err == nil {
+		r.info = info
+	}
+	return nil
+}
+
+func (r *LinkRegistry) sorted() []*Link {
+	links := make([]*Link, 0, len(r.links))
+	for _, link := range r.links {
+		links = append(links, link)
+	}
+	sort.Sort(linksByCreation(links))
+	return links
+}
+
+type linksByCreation []*Link
+
+func (l linksByCreation) Len() int           { return len(l) }
+func (l linksByCreation) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l linksByCreation) Less(i, j int) bool { return l[i].Created.Before(l[j].Created) }
+
+func (r *LinkRegistry) ByCard(cardId string) (Link, bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.refresh()
+	link, ok := r.links[cardId]
+	if !ok {
+		return Link{}, false
+	}
+	return *link, true
+}
+
+func (r *LinkRegistry) ByIssue(key string) (Link, bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.refresh()
+	for _, link := range r.links {
+		if link.IssueKey == key {
+			return *link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Find looks a link up by card id, card short link url or issue key.
+func (r *LinkRegistry) Find(id string) (Link, bool) {
+	if link, ok := r.ByCard(id); ok {
+		return link, true
+	}
+	if link, ok := r.ByIssue(id); ok {
+		return link, true
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, link := range r.links {
+		if link.CardUrl == id {
+			return *link, true
+		}
+	}
+	return Link{}, false
+}
+
+func (r *LinkRegistry) All() []Link {
+	r.Lock()
+	defer r.Unlock()
+	r.refresh()
+	res := []Link{}
+	for _, link := range r.sorted() {
+		res = append(res, *link)
+	}
+	return res
+}
+
+func (r *LinkRegistry) Put(link Link) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.refresh(); err != nil {
+		return err
+	}
+	if link.Created.IsZero() {
+		link.Created = time.Now()
+	}
+	r.links[link.CardId] = &link
+	return r.save()
+}
+
+// SetSyncState records the outcome of syncing the pair of the given card.
+func (r *LinkRegistry) SetSyncState(cardId string, syncErr error) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.refresh(); err != nil {
+		return err
+	}
+	link, ok := r.links[cardId]
+	if !ok {
+		return fmt.Errorf("card %s is not linked", cardId)
+	}
+	link.LastSync = time.Now()
+	if syncErr != nil {
+		link.SyncState, link.LastError = LinkError, syncErr.Error()
+	} else {
+		link.SyncState, link.LastError = LinkSynced, ""
+	}
+	return r.save()
+}
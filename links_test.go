@@ -0,0 +1,71 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLinkRegistrySeesTheLinksOfAnotherInstance(t *testing.T) {
+	defer inTempDir(t)()
+
+	writer, err := OpenLinkRegistry(LINKS_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenLinkRegistry(LINKS_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.All()) != 0 {
+		t.Fatalf("a new registry has the links %+v", reader.All())
+	}
+
+	link := Link{CardId: "5800000000000000000000d1", CardUrl: "https://trello.com/c/aaaa0001", IssueKey: "GS-101", SyncState: LinkCreated}
+	if err := writer.Put(link); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{link.CardId, link.CardUrl, link.IssueKey} {
+		if found, ok := reader.Find(id); !ok || found.CardId != link.CardId {
+			t.Errorf("Find(%q) in the other registry = %+v, %v", id, found, ok)
+		}
+	}
+	if _, ok := reader.Find("GS-999"); ok {
+		t.Errorf("Find found a link for an unknown issue")
+	}
+
+	// a put keeps the links the other instance wrote
+	if err := reader.Put(Link{CardId: "5800000000000000000000d2", IssueKey: "GS-102", SyncState: LinkLinked}); err != nil {
+		t.Fatal(err)
+	}
+	if links := writer.All(); len(links) != 2 || links[0].IssueKey != "GS-101" || links[1].IssueKey != "GS-102" {
+		t.Errorf("the registries have the links %+v, expected GS-101 and GS-102", links)
+	}
+	if err := writer.SetSyncState(link.CardId, nil); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := reader.ByCard(link.CardId); found.SyncState != LinkSynced {
+		t.Errorf("the sync state seen by the other registry is %q, expected %q", found.SyncState, LinkSynced)
+	}
+
+	// the file is replaced by a complete registry, the temporary files are gone
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != LINKS_FILE_NAME {
+		names := []string{}
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("the registry directory holds %v, expected only %s", names, LINKS_FILE_NAME)
+	}
+	bytes, err := ioutil.ReadFile(LINKS_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := []Link{}
+	if err := json.Unmarshal(bytes, &saved); err != nil || len(saved) != 2 {
+		t.Errorf("the saved registry holds %d link(s), error %v", len(saved), err)
+	}
+}
@@ -15,10 +15,20 @@ func Logger(inner http.Handler, name string) http.Handler {
 		log.Printf(
 			"%s\t%s\t%s\t%s",
 			r.Method,
-			r.RequestURI,
+			redactedURI(r),
 			name,
 			time.Since(start),
 		)
 	})
 }
 
+// redactedURI is the request uri without the value of the secret query parameter that Jira Server
+// webhooks authenticate with.
+func redactedURI(r *http.Request) string {
+	query := r.URL.Query()
+	if _, ok := query["secret"]; !ok {
+		return r.RequestURI
+	}
+	query.Set("secret", "REDACTED")
+	return r.URL.Path + "?" + query.Encode()
+}
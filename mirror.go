@@ -0,0 +1,155 @@
+package xap_trello
+
+import (
+	"fmt"
+	"github.com/barakb/go-trello"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Mirrored comments carry a marker with the id of the original comment, in Jira it is
+// an {anchor} macro and in Trello an empty link, both are not rendered.
+var (
+	trelloMarkerPattern = regexp.MustCompile(`\{anchor:xt-trello-([0-9a-f]+)\}`)
+	jiraMarkerPattern   = regexp.MustCompile(`\[\]\(#xt-jira-([0-9]+)\)`)
+)
+
+// Mentions are translated through the identity directory so the mentioned person is notified on
+// the other side too.
+var (
+	// after whitespace, so that the @ of an email address is not a mention
+	trelloMentionPattern = regexp.MustCompile(`(^|\s)@([A-Za-z0-9_]+)`)
+	jiraMentionPattern   = regexp.MustCompile(`\[~(?:accountid:)?([^\]]+)\]`)
+)
+
+func trelloToJiraMentions(directory *IdentityDirectory, text string) string {
+	return trelloMentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+		found := trelloMentionPattern.FindStringSubmatch(mention)
+		if identity, ok := directory.ByTrello(found[2]); ok && identity.JiraMention() != "" {
+			return found[1] + identity.JiraMention()
+		}
+		return mention
+	})
+}
+
+func jiraToTrelloMentions(directory *IdentityDirectory, text string) string {
+	return jiraMentionPattern.ReplaceAllStringFunc(text, func(mention string) string {
+		user := jiraMentionPattern.FindStringSubmatch(mention)[1]
+		if identity, ok := directory.ByJira(user); ok && identity.TrelloUsername != "" {
+			return "@" + identity.TrelloUsername
+		}
+		return mention
+	})
+}
+
+func trelloMarker(actionId string) string {
+	return fmt.Sprintf("{anchor:xt-trello-%s}", actionId)
+}
+
+func jiraMarker(commentId string) string {
+	return fmt.Sprintf("[](#xt-jira-%s)", commentId)
+}
+
+// MirrorDiscussion copies the comments and attachments of each linked card to its Jira issue and back.
+func MirrorDiscussion(xapTrello *Trello, xapJira *Jira, trelloCardByJiraKey map[string]trello.Card) {
+	directory, err := LoadIdentities()
+	if err != nil {
+		log.Printf("Failed to load the identity directory, mentions are kept as is, error is: %s\n", err.Error())
+		directory = &IdentityDirectory{}
+	}
+	for key, card := range trelloCardByJiraKey {
+		if err := MirrorComments(xapTrello, xapJira, directory, key, card.Id); err != nil {
+			log.Printf("Failed to mirror comments between card %q and issue %s, error is: %s\n", card.Name, key, err.Error())
+		}
+		if err := MirrorAttachments(xapTrello, xapJira, key, card.Id); err != nil {
+			log.Printf("Failed to mirror attachments between card %q and issue %s, error is: %s\n", card.Name, key, err.Error())
+		}
+	}
+}
+
+func MirrorComments(xapTrello *Trello, xapJira *Jira, directory *IdentityDirectory, key, cardId string) error {
+	trelloComments, err := xapTrello.Comments(cardId)
+	if err != nil {
+		return err
+	}
+	jiraComments, err := xapJira.Comments(key)
+	if err != nil {
+		return err
+	}
+
+	mirroredToJira := map[string]bool{}
+	for _, comment := range jiraComments {
+		if found := trelloMarkerPattern.FindStringSubmatch(comment.Body); found != nil {
+			mirroredToJira[found[1]] = true
+		}
+	}
+	mirroredToTrello := map[string]bool{}
+	for _, comment := range trelloComments {
+		if found := jiraMarkerPattern.FindStringSubmatch(comment.Data.Text); found != nil {
+			mirroredToTrello[found[1]] = true
+		}
+	}
+
+	// Trello returns the newest comment first
+	for i := len(trelloComments) - 1; 0 <= i; i-- {
+		comment := trelloComments[i]
+		if mirroredToJira[comment.Id] || jiraMarkerPattern.MatchString(comment.Data.Text) {
+			continue
+		}
+		body := fmt.Sprintf("*[Trello] %s:* %s\n\n%s", comment.MemberCreator.FullName,
+			trelloToJiraMentions(directory, comment.Data.Text), trelloMarker(comment.Id))
+		if err := xapJira.AddComment(key, body); err != nil {
+			return err
+		}
+		log.Printf("Comment %s of card %s -> %s\n", comment.Id, cardId, key)
+	}
+	for _, comment := range jiraComments {
+		if mirroredToTrello[comment.Id] || trelloMarkerPattern.MatchString(comment.Body) {
+			continue
+		}
+		text := fmt.Sprintf("**[Jira] %s:** %s\n\n%s", comment.Author.DisplayName,
+			jiraToTrelloMentions(directory, comment.Body), jiraMarker(comment.Id))
+		if err := xapTrello.AddComment(cardId, text); err != nil {
+			return err
+		}
+		log.Printf("Comment %s of issue %s -> card %s\n", comment.Id, key, cardId)
+	}
+	return nil
+}
+
+// MirrorAttachments links every Trello attachment from the Jira issue and every Jira attachment from the card.
+func MirrorAttachments(xapTrello *Trello, xapJira *Jira, key, cardId string) error {
+	trelloAttachments, err := xapTrello.Attachments(cardId)
+	if err != nil {
+		return err
+	}
+	jiraAttachments, err := xapJira.Attachments(key)
+	if err != nil {
+		return err
+	}
+
+	linkedFromTrello := map[string]bool{}
+	for _, attachment := range trelloAttachments {
+		linkedFromTrello[attachment.Url] = true
+		if strings.HasPrefix(attachment.Url, xapJira.Url) {
+			// a link we created for a Jira attachment
+			continue
+		}
+		// remote links are keyed by globalId so linking again is a no-op
+		globalId := fmt.Sprintf("xt-trello-attachment-%s", attachment.Id)
+		if err := xapJira.AddRemoteLink(key, globalId, "[Trello] "+attachment.Name, attachment.Url); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range jiraAttachments {
+		if linkedFromTrello[attachment.Content] {
+			continue
+		}
+		if err := xapTrello.AttachLink(cardId, "[Jira] "+attachment.Filename, attachment.Content); err != nil {
+			return err
+		}
+		log.Printf("Attachment %s of issue %s -> card %s\n", attachment.Filename, key, cardId)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package xap_trello
+
+import "testing"
+
+func TestTrelloToJiraMentions(t *testing.T) {
+	directory := &IdentityDirectory{Identities: []Identity{
+		{Name: "Barak", TrelloUsername: "barakb", JiraName: "barak"},
+		{Name: "Yael", TrelloUsername: "yael", JiraAccountId: "5b10a2844c20165700ede21g"},
+	}}
+	cases := map[string]string{
+		"@barakb please look":             "[~barak] please look",
+		"thanks @barakb and @yael":        "thanks [~barak] and [~accountid:5b10a2844c20165700ede21g]",
+		"@barakb @yael":                   "[~barak] [~accountid:5b10a2844c20165700ede21g]",
+		"line one\n@yael line two":        "line one\n[~accountid:5b10a2844c20165700ede21g] line two",
+		"write to barakb@gigaspaces.com":  "write to barakb@gigaspaces.com",
+		"mail yael@barakb.io or @someone": "mail yael@barakb.io or @someone",
+	}
+	for text, expected := range cases {
+		if got := trelloToJiraMentions(directory, text); got != expected {
+			t.Errorf("trelloToJiraMentions(%q) = %q, expected %q", text, got, expected)
+		}
+	}
+}
+
+func TestMirrorDoesNotMirrorBack(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const cardId, key = "5800000000000000000000d1", "GS-101"
+	if err := xapTrello.AddComment(cardId, "failover is fixed on the 12.1 branch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xapJira.AddComment(key, "please verify on the nightly build"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xapTrello.AttachLink(cardId, "logs", "https://example.com/logs.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xapJira.Attach(key, "dump.txt", "text/plain", []byte("dump")); err != nil {
+		t.Fatal(err)
+	}
+
+	directory := &IdentityDirectory{}
+	// the second run sees the mirrored comments and attachments of the first one
+	for run := 0; run < 2; run++ {
+		if err := MirrorComments(xapTrello, xapJira, directory, key, cardId); err != nil {
+			t.Fatal(err)
+		}
+		if err := MirrorAttachments(xapTrello, xapJira, key, cardId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trelloComments, err := xapTrello.Comments(cardId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trelloComments) != 2 || !jiraMarkerPattern.MatchString(trelloComments[0].Data.Text) {
+		t.Errorf("the card has the comments %+v, expected its own and the mirrored Jira one", trelloComments)
+	}
+	jiraComments, err := xapJira.Comments(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jiraComments) != 2 || !trelloMarkerPattern.MatchString(jiraComments[1].Body) {
+		t.Errorf("the issue has the comments %+v, expected its own and the mirrored Trello one", jiraComments)
+	}
+	attachments, err := xapTrello.Attachments(cardId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 {
+		t.Errorf("the card has the attachments %+v, expected its own and a link to the Jira one", attachments)
+	}
+	jiraAttachments, err := xapJira.Attachments(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jiraAttachments) != 1 {
+		t.Errorf("the issue has the attachments %+v, expected only its own", jiraAttachments)
+	}
+}
@@ -0,0 +1,345 @@
+package xap_trello
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// the local clone of the pages branch
+const PAGES_DIR = "pages"
+
+const (
+	DEFAULT_PAGES_BRANCH = "gh-pages"
+	DEFAULT_PAGES_TITLE  = "XAP burndown"
+)
+
+// PagesConfig publishes a static site of the archived sprints to a branch of the archive repository.
+type PagesConfig struct {
+	// publish at rollover and on the schedule
+	Enabled bool `json:"enabled"`
+	// gh-pages by default
+	Branch string `json:"branch"`
+	// how often the site is published, like "1h", only at rollover when empty
+	Schedule string `json:"schedule"`
+	Title    string `json:"title"`
+}
+
+// PagesSprint is a sprint of the site, an entry of data.json.
+type PagesSprint struct {
+	Sprint     Sprint `json:"sprint"`
+	Page       string `json:"page"`
+	Data       string `json:"data"`
+	Chart      string `json:"chart"`
+	Planned    int    `json:"planned"`
+	InProgress int    `json:"in_progress"`
+	Done       int    `json:"done"`
+}
+
+// PagesBundle is data.json, the index of the site.
+type PagesBundle struct {
+	Title string `json:"title"`
+	// the last change of the sprints, the site only changes with them
+	Updated time.Time     `json:"updated"`
+	Current *PagesSprint  `json:"current"`
+	Sprints []PagesSprint `json:"sprints"`
+	// points done per sprint, by start date
+	Velocity []int `json:"velocity"`
+	// the archived files that are not burndown data
+	Failed []RestoreFailure `json:"failed"`
+}
+
+// pagesSprintData is sprints/<sprint>.json.
+type pagesSprintData struct {
+	Sprint   Sprint        `json:"sprint"`
+	Status   SprintStatus  `json:"status"`
+	Timeline []TrelloState `json:"timeline"`
+}
+
+var pageNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func pageName(sprint string) string {
+	return pageNamePattern.ReplaceAllString(sprint, "_")
+}
+
+// ExportPages renders the archived sprints into dir: index.html with the chart of the current sprint and the
+// velocity, a page, a chart and the data of every sprint and data.json. It returns the written files,
+// relative to dir.
+func ExportPages(dir string, title string) ([]string, error) {
+	history, err := ReadHistory()
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Failed: []RestoreFailure{}}
+	entries, archived, err := readPagesArchive(history, report)
+	if err != nil {
+		return nil, err
+	}
+	if err := history.Save(); err != nil {
+		return nil, err
+	}
+	if title == "" {
+		title = DEFAULT_PAGES_TITLE
+	}
+
+	files := []string{}
+	write := func(name string, content []byte) error {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		files = append(files, name)
+		return ioutil.WriteFile(path, content, 0644)
+	}
+
+	bundle := &PagesBundle{Title: title, Sprints: []PagesSprint{}, Velocity: []int{}, Failed: report.Failed}
+	var current []byte
+	for index, entry := range entries {
+		data := archived[index]
+		if data == nil {
+			continue
+		}
+		b := &Burndown{BurnDownData: *data}
+		now := time.Now()
+		if b.Sprint.End.Before(now) {
+			now = b.Sprint.End
+		}
+		status := b.createSprintAt(b.compressTimeline(), now)
+		name := pageName(entry.Sprint.Name)
+		sprint := PagesSprint{Sprint: entry.Sprint, Page: "sprints/" + name + ".html", Data: "sprints/" + name + ".json",
+			Chart: "sprints/" + name + ".svg", Planned: entry.Planned, InProgress: entry.InProgress, Done: entry.Done}
+
+		chart := RenderBurndownSVG(status)
+		sprintData, err := json.MarshalIndent(pagesSprintData{Sprint: entry.Sprint, Status: *status, Timeline: data.TrelloEvents}, "", "  ")
+		if err != nil {
+			return files, err
+		}
+		page := &bytes.Buffer{}
+		if err := sprintPageTemplate.Execute(page, map[string]interface{}{"Title": title, "Sprint": sprint, "Status": status,
+			"Chart": template.HTML(chart)}); err != nil {
+			return files, err
+		}
+		for _, file := range []struct {
+			name    string
+			content []byte
+		}{{sprint.Chart, chart}, {sprint.Data, sprintData}, {sprint.Page, page.Bytes()}} {
+			if err := write(file.name, file.content); err != nil {
+				return files, err
+			}
+		}
+		bundle.Sprints = append(bundle.Sprints, sprint)
+		bundle.Velocity = append(bundle.Velocity, sprint.Done)
+		if 0 < len(data.TrelloEvents) && bundle.Updated.Before(data.TrelloEvents[len(data.TrelloEvents)-1].Time) {
+			bundle.Updated = data.TrelloEvents[len(data.TrelloEvents)-1].Time
+		}
+		current = chart
+	}
+	if 0 < len(bundle.Sprints) {
+		bundle.Current = &bundle.Sprints[len(bundle.Sprints)-1]
+	}
+
+	velocity := RenderVelocitySVG(bundle.Sprints)
+	bundleData, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	index := &bytes.Buffer{}
+	if err := indexPageTemplate.Execute(index, map[string]interface{}{"Bundle": bundle, "Chart": template.HTML(current),
+		"Velocity": template.HTML(velocity)}); err != nil {
+		return files, err
+	}
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{{"velocity.svg", velocity}, {"data.json", bundleData}, {"index.html", index.Bytes()}, {".nojekyll", []byte{}}} {
+		if err := write(file.name, file.content); err != nil {
+			return files, err
+		}
+	}
+	log.Printf("Exported %d sprint(s) to %s\n", len(bundle.Sprints), dir)
+	return files, nil
+}
+
+// readPagesArchive imports the sprints archived since the last restore into history and reads the data of every
+// sprint of history, under the lock of ARCHIVE_DIR so a burndown save or an archive pull does not change the files
+// meanwhile. The data of a sprint that can not be read is nil and reported.
+func readPagesArchive(history *HistoryStore, report *RestoreReport) ([]*SprintHistory, []*BurnDownData, error) {
+	lock := dirLock(ARCHIVE_DIR)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := importArchive(history, report); err != nil {
+		return nil, nil, err
+	}
+	entries := history.List()
+	archived := make([]*BurnDownData, len(entries))
+	for index, entry := range entries {
+		data, err := readBurnDownData(filepath.Join(ARCHIVE_DIR, filepath.FromSlash(entry.File)))
+		if err != nil {
+			report.Failed = append(report.Failed, RestoreFailure{File: entry.File, Error: err.Error()})
+			continue
+		}
+		archived[index] = data
+	}
+	return entries, archived, nil
+}
+
+// RenderVelocitySVG draws the points done in each sprint, as bars.
+func RenderVelocitySVG(sprints []PagesSprint) []byte {
+	const width, height, margin = 800, 300, 50
+	max := 1
+	for _, sprint := range sprints {
+		if max < sprint.Done {
+			max = sprint.Done
+		}
+	}
+	step := float64(width-2*margin) / float64(len(sprints)+1)
+	y := func(v int) float64 {
+		return height - margin - float64(v)*float64(height-2*margin)/float64(max)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", width, height)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(buf, `<text x="%d" y="25" font-size="16">Velocity</text>`+"\n", margin)
+	fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", margin, height-margin, width-margin, height-margin)
+	fmt.Fprintf(buf, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`+"\n", margin-5, y(max)+4, max)
+	for index, sprint := range sprints {
+		x := margin + step*float64(index+1)
+		fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#3366cc"/>`+"\n", x-step/3, y(sprint.Done), 2*step/3, y(0)-y(sprint.Done))
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`+"\n", x, y(sprint.Done)-4, sprint.Done)
+		fmt.Fprintf(buf, `<text x="%.1f" y="%d" text-anchor="end" transform="rotate(-45 %.1f %d)">%s</text>`+"\n",
+			x, height-margin+15, x, height-margin+15, html.EscapeString(sprint.Sprint.Name))
+	}
+	fmt.Fprintln(buf, "</svg>")
+	return buf.Bytes()
+}
+
+var indexPageTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Bundle.Title}}</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{padding:4px 12px;text-align:right}td:first-child,th:first-child{text-align:left}</style>
+</head><body>
+<h1>{{.Bundle.Title}}</h1>
+{{if .Bundle.Current}}<h2>Sprint {{.Bundle.Current.Sprint.Name}}</h2>
+{{.Chart}}{{end}}
+<h2>Velocity</h2>
+{{.Velocity}}
+<h2>Sprints</h2>
+<table>
+<tr><th>Sprint</th><th>Start</th><th>End</th><th>Done</th><th>In progress</th><th>Planned</th></tr>
+{{range .Bundle.Sprints}}<tr><td><a href="{{.Page}}">{{.Sprint.Name}}</a></td><td>{{.Sprint.Start.Format "2006-01-02"}}</td><td>{{.Sprint.End.Format "2006-01-02"}}</td><td>{{.Done}}</td><td>{{.InProgress}}</td><td>{{.Planned}}</td></tr>
+{{end}}</table>
+<p>Updated {{.Bundle.Updated.Format "2006-01-02 15:04"}}, the data is in <a href="data.json">data.json</a>.</p>
+</body></html>
+`))
+
+var sprintPageTemplate = template.Must(template.New("sprint").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}} - sprint {{.Sprint.Sprint.Name}}</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{padding:4px 12px;text-align:right}td:first-child,th:first-child{text-align:left}</style>
+</head><body>
+<p><a href="../index.html">{{.Title}}</a></p>
+<h1>Sprint {{.Sprint.Sprint.Name}}</h1>
+<p>{{.Sprint.Sprint.Start.Format "2006-01-02"}} to {{.Sprint.Sprint.End.Format "2006-01-02"}}, {{.Sprint.Done}} points done, {{.Sprint.InProgress}} in progress, {{.Sprint.Planned}} planned.</p>
+{{.Chart}}
+<table>
+<tr><th>Day</th><th>Total</th><th>Remaining</th><th>Expected</th></tr>
+{{range .Status.Days}}<tr><td>{{.Name}}</td><td>{{.Total}}</td><td>{{.Top}}</td><td>{{printf "%.1f" .Expected}}</td></tr>
+{{end}}</table>
+<p>The data is in <a href="../{{.Sprint.Data}}">{{.Sprint.Data}}</a>.</p>
+</body></html>
+`))
+
+// PagesPublisher commits the exported site to the pages branch of the archive repository and pushes it.
+type PagesPublisher struct {
+	config PagesConfig
+	// serializes the exports, the archiver serializes the git operations
+	work     sync.Mutex
+	archiver *Archiver
+	done     chan struct{}
+}
+
+// NewPagesPublisher returns the publisher of config, with a schedule it also publishes in the background.
+func NewPagesPublisher(config PagesConfig, archive ArchiveConfig) (*PagesPublisher, error) {
+	if config.Branch == "" {
+		config.Branch = DEFAULT_PAGES_BRANCH
+	}
+	// the site is generated, the last export wins
+	archive.Branch, archive.Conflict, archive.Schedule = config.Branch, ConflictLocal, config.Schedule
+	archiver := newArchiver(archive)
+	archiver.local, archiver.work = PAGES_DIR, dirLock(PAGES_DIR)
+	publisher := &PagesPublisher{config: config, archiver: archiver, done: make(chan struct{})}
+	if config.Enabled && config.Schedule != "" {
+		interval, err := time.ParseDuration(config.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("bad pages schedule %q: %s", config.Schedule, err.Error())
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("bad pages schedule %q: must be positive", config.Schedule)
+		}
+		go publisher.loop(interval)
+	}
+	return publisher, nil
+}
+
+func (p *PagesPublisher) loop(interval time.Duration) {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(interval):
+		}
+		if err := p.Publish(); err != nil {
+			log.Printf("Error %q, while publishing the pages\n", err.Error())
+		}
+	}
+}
+
+// Stop ends the background publishing.
+func (p *PagesPublisher) Stop() {
+	close(p.done)
+}
+
+// Publish exports the site into PAGES_DIR and commits and pushes it when it changed.
+func (p *PagesPublisher) Publish() error {
+	p.work.Lock()
+	defer p.work.Unlock()
+	if err := p.sync(); err != nil {
+		return err
+	}
+	files, err := ExportPages(PAGES_DIR, p.config.Title)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		p.archiver.Add(file)
+	}
+	return p.archiver.Flush()
+}
+
+// sync starts PAGES_DIR from the pages branch when there is one.
+func (p *PagesPublisher) sync() error {
+	if _, err := os.Stat(filepath.Join(PAGES_DIR, ".git")); err == nil {
+		return nil
+	}
+	git, err := NewArchiveRepository(p.archiver.config, PAGES_DIR)
+	if err != nil {
+		return err
+	}
+	if err := git.Init(); err != nil {
+		return err
+	}
+	return git.Rebase()
+}
+
+// State returns the queue of the publisher.
+func (p *PagesPublisher) State() ArchiverState {
+	return p.archiver.State()
+}
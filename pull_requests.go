@@ -0,0 +1,434 @@
+package xap_trello
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const PULL_REQUEST_LINKS_FILE_NAME = "pull-requests.json"
+
+const (
+	PullRequestOpen   = "open"
+	PullRequestMerged = "merged"
+	PullRequestClosed = "closed"
+)
+
+const (
+	// pull requests updated before are not scanned, the linked ones are still refreshed
+	PULL_REQUEST_SCAN_AGE   = 90 * 24 * time.Hour
+	PULL_REQUEST_SCAN_PAGES = 10
+)
+
+var (
+	// https://trello.com/c/AbCd1234 or https://trello.com/c/AbCd1234/12-card-name
+	cardShortLinkPattern = regexp.MustCompile(`trello\.com/c/([A-Za-z0-9]{8})`)
+	// a short link as a part of a branch name, like feature/AbCd1234-fix-login
+	branchShortLinkPattern = regexp.MustCompile(`(?:^|[/_-])([A-Za-z0-9]{8})(?:[/_-]|$)`)
+	jiraKeyPattern         = regexp.MustCompile(`\b([A-Z][A-Z0-9]+-\d+)\b`)
+	pullRequestBadges      = map[string]string{PullRequestOpen: ":construction:", PullRequestMerged: ":white_check_mark:", PullRequestClosed: ":x:"}
+)
+
+// PullRequestLink is a pull request that references cards in its title, body or branch.
+type PullRequestLink struct {
+	Repository string    `json:"repository"`
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	Url        string    `json:"url"`
+	Branch     string    `json:"branch"`
+	State      string    `json:"state"`
+	CardIds    []string  `json:"card_ids"`
+	ShortLinks []string  `json:"short_links"`
+	Updated    time.Time `json:"updated"`
+	// the cards whose badge failed to update, retried by the next scan
+	Stale []string `json:"stale,omitempty"`
+}
+
+// Name is the owner/repo#number of the pull request.
+func (l *PullRequestLink) Name() string {
+	return fmt.Sprintf("%s#%d", l.Repository, l.Number)
+}
+
+func (l *PullRequestLink) hasCard(id string) bool {
+	for index, cardId := range l.CardIds {
+		if cardId == id || l.ShortLinks[index] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// PullRequestRegistry keeps the pull request links in pull-requests.json, keyed by Name.
+type PullRequestRegistry struct {
+	sync.Mutex
+	path  string
+	Pulls map[string]*PullRequestLink `json:"pulls"`
+}
+
+// ReadPullRequestLinks reads pull-requests.json, a missing file yields an empty registry.
+func ReadPullRequestLinks() (*PullRequestRegistry, error) {
+	registry := &PullRequestRegistry{path: PULL_REQUEST_LINKS_FILE_NAME, Pulls: map[string]*PullRequestLink{}}
+	if err := FromJSONFile(registry, PULL_REQUEST_LINKS_FILE_NAME); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if registry.Pulls == nil {
+		registry.Pulls = map[string]*PullRequestLink{}
+	}
+	return registry, nil
+}
+
+// ByCard returns the pull requests of the card with the id or short link, by number.
+func (r *PullRequestRegistry) ByCard(id string) []PullRequestLink {
+	r.Lock()
+	defer r.Unlock()
+	res := pullRequestLinks{}
+	for _, link := range r.Pulls {
+		if link.hasCard(id) {
+			res = append(res, *link)
+		}
+	}
+	sort.Sort(res)
+	return res
+}
+
+// CodeComplete tells whether the code of the card is merged: at least one of its pull requests is merged and
+// none is open, a pull request closed without merging is a replaced one. A card without pull requests is
+// complete. The pull requests that are not merged are returned.
+func (r *PullRequestRegistry) CodeComplete(id string) (bool, []PullRequestLink) {
+	pulls := r.ByCard(id)
+	merged, open := 0, 0
+	pending := []PullRequestLink{}
+	for _, pull := range pulls {
+		switch pull.State {
+		case PullRequestMerged:
+			merged++
+			continue
+		case PullRequestOpen:
+			open++
+		}
+		pending = append(pending, pull)
+	}
+	return len(pulls) == 0 || (0 < merged && open == 0), pending
+}
+
+func (r *PullRequestRegistry) Save() error {
+	r.Lock()
+	defer r.Unlock()
+	return ToJSONFile(r, r.path)
+}
+
+type pullRequestLinks []PullRequestLink
+
+func (l pullRequestLinks) Len() int      { return len(l) }
+func (l pullRequestLinks) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l pullRequestLinks) Less(i, j int) bool {
+	if l[i].Repository != l[j].Repository {
+		return l[i].Repository < l[j].Repository
+	}
+	return l[i].Number < l[j].Number
+}
+
+// PullRequestItem is a pull request and card pair of a linker run.
+type PullRequestItem struct {
+	Pull   string `json:"pull"`
+	CardId string `json:"card_id"`
+	State  string `json:"state"`
+	// linked, updated or unchanged
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+type PullRequestReport struct {
+	Time    time.Time         `json:"time"`
+	Scanned int               `json:"scanned"`
+	Items   []PullRequestItem `json:"items"`
+}
+
+func (r *PullRequestReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%d pull request(s) scanned on %s\n", r.Scanned, r.Time.Format("2006-01-02 15:04"))
+	for _, item := range r.Items {
+		fmt.Fprintf(w, "%-10s %-30s %-26s %s %s\n", item.Action, item.Pull, item.CardId, item.State, item.Error)
+	}
+}
+
+// pullRequestState is open, merged or closed, the list api only tells merged by merged_at.
+func pullRequestState(pull *github.PullRequest) string {
+	if pull.MergedAt != nil || pull.GetMerged() {
+		return PullRequestMerged
+	}
+	if pull.GetState() == "closed" {
+		return PullRequestClosed
+	}
+	return PullRequestOpen
+}
+
+// pullRequestReferences returns the card short links and Jira keys of the title, body and branch.
+func pullRequestReferences(title, body, branch string) (shortLinks []string, keys []string) {
+	seen := map[string]bool{}
+	add := func(list *[]string, value string) {
+		if !seen[value] {
+			seen[value] = true
+			*list = append(*list, value)
+		}
+	}
+	for _, text := range []string{title, body, branch} {
+		for _, match := range cardShortLinkPattern.FindAllStringSubmatch(text, -1) {
+			add(&shortLinks, match[1])
+		}
+		for _, match := range jiraKeyPattern.FindAllStringSubmatch(text, -1) {
+			add(&keys, match[1])
+		}
+	}
+	for _, match := range branchShortLinkPattern.FindAllStringSubmatch(branch, -1) {
+		// most words of a branch name are not short links, they are dropped when no card has them
+		if strings.IndexFunc(match[1], func(r rune) bool { return '0' <= r && r <= '9' }) != -1 {
+			add(&shortLinks, match[1])
+		}
+	}
+	return shortLinks, keys
+}
+
+// PullRequestLinker links the pull requests of the configured repositories to the cards they reference.
+type PullRequestLinker struct {
+	Trello   *Trello
+	GitHub   *github.Client
+	Links    *LinkRegistry
+	Registry *PullRequestRegistry
+	// short link or card id to the card, nil when there is no such card
+	cards map[string]*pullRequestCard
+}
+
+type pullRequestCard struct {
+	Id, ShortLink string
+}
+
+// NewPullRequestLinker returns a linker with the current links, client is only needed to scan the repositories.
+func NewPullRequestLinker(client *github.Client) (*PullRequestLinker, error) {
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return nil, err
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return nil, err
+	}
+	registry, err := ReadPullRequestLinks()
+	if err != nil {
+		return nil, err
+	}
+	return &PullRequestLinker{Trello: xapTrello, GitHub: client, Links: links, Registry: registry, cards: map[string]*pullRequestCard{}}, nil
+}
+
+// the scans of the handler and of the background linker do not overlap
+var pullRequestScan sync.Mutex
+
+// LinkPullRequests scans the recent pull requests of the configured repositories, links them to the
+// cards they reference and refreshes the badges of the linked ones.
+func LinkPullRequests() (*PullRequestReport, error) {
+	repositories := ReadConfig().GitHub.Repositories
+	if len(repositories) == 0 {
+		return nil, fmt.Errorf("no repositories to scan, set github.repositories in %s", CONFIG_FILE_NAME)
+	}
+	pullRequestScan.Lock()
+	defer pullRequestScan.Unlock()
+	client, err := GitHubClient()
+	if err != nil {
+		return nil, err
+	}
+	linker, err := NewPullRequestLinker(client)
+	if err != nil {
+		return nil, err
+	}
+	report := &PullRequestReport{Time: time.Now(), Items: []PullRequestItem{}}
+	seen := map[string]bool{}
+	for _, repository := range repositories {
+		pulls, err := linker.recentPullRequests(repository)
+		if err != nil {
+			return report, err
+		}
+		for _, pull := range pulls {
+			report.Scanned++
+			seen[fmt.Sprintf("%s#%d", repository, pull.GetNumber())] = true
+			report.Items = append(report.Items, linker.Link(repository, pull)...)
+		}
+	}
+	// linked pull requests that were not updated lately may still have changed state
+	for name, link := range linker.Registry.Pulls {
+		if seen[name] || link.State != PullRequestOpen {
+			continue
+		}
+		owner, repo := splitRepository(link.Repository)
+		pull, _, err := linker.GitHub.PullRequests.Get(context.Background(), owner, repo, link.Number)
+		if err != nil {
+			report.Items = append(report.Items, PullRequestItem{Pull: name, State: link.State, Action: ImportFailed, Error: err.Error()})
+			continue
+		}
+		report.Scanned++
+		report.Items = append(report.Items, linker.Link(link.Repository, pull)...)
+	}
+	return report, linker.Registry.Save()
+}
+
+func splitRepository(repository string) (string, string) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 {
+		return repository, ""
+	}
+	return parts[0], parts[1]
+}
+
+func (linker *PullRequestLinker) recentPullRequests(repository string) ([]*github.PullRequest, error) {
+	owner, repo := splitRepository(repository)
+	options := &github.PullRequestListOptions{State: "all", Sort: "updated", Direction: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	res := []*github.PullRequest{}
+	for page := 1; page <= PULL_REQUEST_SCAN_PAGES; page++ {
+		options.Page = page
+		pulls, response, err := linker.GitHub.PullRequests.List(context.Background(), owner, repo, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the pull requests of %s: %s", repository, err.Error())
+		}
+		for _, pull := range pulls {
+			if pull.UpdatedAt != nil && time.Since(*pull.UpdatedAt) > PULL_REQUEST_SCAN_AGE {
+				return res, nil
+			}
+			res = append(res, pull)
+		}
+		if response.NextPage == 0 {
+			break
+		}
+	}
+	return res, nil
+}
+
+// card resolves a short link or card id, the answer is cached for the run.
+func (linker *PullRequestLinker) card(id string) *pullRequestCard {
+	if card, ok := linker.cards[id]; ok {
+		return card
+	}
+	var res *pullRequestCard
+	if card, err := linker.Trello.Card(id); err == nil && card.Id != "" {
+		res = &pullRequestCard{Id: card.Id, ShortLink: card.ShortLink}
+	}
+	linker.cards[id] = res
+	return res
+}
+
+// referencedCards returns the existing cards referenced by short link or by the Jira key of a linked issue.
+func (linker *PullRequestLinker) referencedCards(title, body, branch string) []*pullRequestCard {
+	shortLinks, keys := pullRequestReferences(title, body, branch)
+	for _, key := range keys {
+		if link, ok := linker.Links.ByIssue(key); ok {
+			shortLinks = append(shortLinks, link.CardId)
+		}
+	}
+	cards := []*pullRequestCard{}
+	seen := map[string]bool{}
+	for _, id := range shortLinks {
+		if card := linker.card(id); card != nil && !seen[card.Id] {
+			seen[card.Id] = true
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// Link records the cards pull references and brings their badges to the state of the pull request.
+func (linker *PullRequestLinker) Link(repository string, pull *github.PullRequest) []PullRequestItem {
+	cards := linker.referencedCards(pull.GetTitle(), pull.GetBody(), pull.Head.GetRef())
+	name := fmt.Sprintf("%s#%d", repository, pull.GetNumber())
+	linker.Registry.Lock()
+	link, known := linker.Registry.Pulls[name]
+	if !known {
+		if len(cards) == 0 {
+			linker.Registry.Unlock()
+			return nil
+		}
+		link = &PullRequestLink{Repository: repository, Number: pull.GetNumber(), CardIds: []string{}, ShortLinks: []string{}}
+		linker.Registry.Pulls[name] = link
+	}
+	previous := link.State
+	link.Title, link.Url, link.Branch, link.State, link.Updated = pull.GetTitle(), pull.GetHTMLURL(), pull.Head.GetRef(), pullRequestState(pull), time.Now()
+	added := map[string]bool{}
+	for _, card := range cards {
+		if !link.hasCard(card.Id) {
+			link.CardIds = append(link.CardIds, card.Id)
+			link.ShortLinks = append(link.ShortLinks, card.ShortLink)
+			added[card.Id] = true
+		}
+	}
+	stale := map[string]bool{}
+	for _, cardId := range link.Stale {
+		stale[cardId] = true
+	}
+	snapshot := *link
+	linker.Registry.Unlock()
+
+	items := []PullRequestItem{}
+	failed := []string{}
+	for _, cardId := range snapshot.CardIds {
+		item := PullRequestItem{Pull: name, CardId: cardId, State: snapshot.State, Action: ImportUnchanged}
+		if added[cardId] {
+			item.Action = "linked"
+		} else if previous != snapshot.State || stale[cardId] {
+			item.Action = ImportUpdated
+		} else {
+			// the badge is up to date, the card is not read again
+			items = append(items, item)
+			continue
+		}
+		if err := linker.badge(cardId, &snapshot); err != nil {
+			log.Printf("Failed to update the badge of %s on card %s, error is: %s\n", name, cardId, err.Error())
+			item.Action, item.Error = ImportFailed, err.Error()
+			failed = append(failed, cardId)
+		}
+		items = append(items, item)
+	}
+	linker.Registry.Lock()
+	link.Stale = failed
+	linker.Registry.Unlock()
+	return items
+}
+
+// badge puts [<state emoji> owner/repo#n <state>](url) in the card description, replacing the badge of an older state.
+func (linker *PullRequestLinker) badge(cardId string, link *PullRequestLink) error {
+	card, err := linker.Trello.Card(cardId)
+	if err != nil {
+		return err
+	}
+	badge := fmt.Sprintf("[%s %s %s](%s)", pullRequestBadges[link.State], link.Name(), link.State, link.Url)
+	if strings.Contains(card.Desc, badge) {
+		return nil
+	}
+	existing := regexp.MustCompile(`\[:[a-z_]+: ` + regexp.QuoteMeta(link.Name()) + ` [a-z]+\]\(` + regexp.QuoteMeta(link.Url) + `\)`)
+	desc := card.Desc
+	if existing.MatchString(desc) {
+		desc = existing.ReplaceAllLiteralString(desc, badge)
+	} else {
+		desc = strings.TrimRight(desc, "\n") + "\n\n" + badge
+	}
+	return linker.Trello.SetCardDesc(cardId, desc)
+}
+
+// StartPullRequestLinker runs LinkPullRequests every interval in the background.
+func StartPullRequestLinker(interval time.Duration) {
+	go func() {
+		for {
+			report, err := LinkPullRequests()
+			if err != nil {
+				log.Printf("Failed to link pull requests, error is: %s\n", err.Error())
+			} else {
+				log.Printf("Linked pull requests, %d scanned\n", report.Scanned)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// readPullRequestEvent reads a webhook payload of fake/fixtures/github.
+func readPullRequestEvent(t *testing.T, dir string) *github.PullRequestEvent {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, "fake", "fixtures", "github", "pull_request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &github.PullRequestEvent{}
+	if err := json.Unmarshal(bytes, event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestLinkUpdatesTheBadgeOnlyWhenTheStateChanges(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, restore := withFakeBackend(t)
+	defer restore()
+	pull := readPullRequestEvent(t, dir).PullRequest
+	cards := &countingTransport{base: http.DefaultTransport, part: "/cards/"}
+	http.DefaultTransport = cards
+	defer func() { http.DefaultTransport = cards.base }()
+
+	link := func(expected string) {
+		linker, err := NewPullRequestLinker(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := linker.Link("xap/xap", pull)
+		if len(items) != 1 || items[0].Action != expected || items[0].Error != "" {
+			t.Fatalf("linked %+v, expected the card to be %s", items, expected)
+		}
+		if err := linker.Registry.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link("linked")
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := xapTrello.Card("aaaa0002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(card.Desc, "xap/xap#42 open") {
+		t.Errorf("no open badge on the card: %q", card.Desc)
+	}
+
+	cards.count = 0
+	link(ImportUnchanged)
+	// only to resolve the short link of the pull request
+	if cards.count != 1 {
+		t.Errorf("an unchanged pull request read or wrote the card %d times", cards.count)
+	}
+
+	merged := true
+	pull.Merged = &merged
+	link(ImportUpdated)
+	if card, err = xapTrello.Card("aaaa0002"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(card.Desc, "xap/xap#42 merged") || strings.Contains(card.Desc, "xap/xap#42 open") {
+		t.Errorf("the badge is not merged: %q", card.Desc)
+	}
+}
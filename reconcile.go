@@ -0,0 +1,276 @@
+package xap_trello
+
+import (
+	"fmt"
+	"github.com/barakb/go-trello"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The role of the first board lists, as counted by scanOnce.
+var listRoles = []string{"Done", "InProgress", "Planned"}
+
+// Jira status category expected for each list role.
+var roleStatusCategory = map[string]string{
+	"Done":       "done",
+	"InProgress": "indeterminate",
+	"Planned":    "new",
+}
+
+type ReconcileItem struct {
+	CardId       string  `json:"card_id,omitempty"`
+	CardName     string  `json:"card_name,omitempty"`
+	CardUrl      string  `json:"card_url,omitempty"`
+	List         string  `json:"list,omitempty"`
+	IssueKey     string  `json:"issue_key,omitempty"`
+	TrelloPoints int     `json:"trello_points"`
+	JiraPoints   float64 `json:"jira_points"`
+	TrelloStatus string  `json:"trello_status,omitempty"`
+	JiraStatus   string  `json:"jira_status,omitempty"`
+	// the card members by their identity name
+	Members []string `json:"members,omitempty"`
+	// the linked pull requests that are not merged, as owner/repo#number
+	PullRequests []string `json:"pull_requests,omitempty"`
+}
+
+// ReconcileReport lists the differences between the board, the Jira sprint and the burndown.
+type ReconcileReport struct {
+	Time               time.Time       `json:"time"`
+	SprintId           int             `json:"sprint_id"`
+	Lists              int             `json:"lists"`
+	CardsWithoutIssues []ReconcileItem `json:"cards_without_issues"`
+	IssuesWithoutCards []ReconcileItem `json:"issues_without_cards"`
+	PointsMismatches   []ReconcileItem `json:"points_mismatches"`
+	StatusMismatches   []ReconcileItem `json:"status_mismatches"`
+	CardsOutsideLists  []ReconcileItem `json:"cards_outside_lists"`
+	// Done cards with pull requests that are not merged and in progress cards whose pull requests are
+	// merged, see GitHubConfig.CodeComplete
+	CodeMismatches []ReconcileItem `json:"code_mismatches"`
+	// the points on the board now against the last state recorded by the burndown
+	Board    TrelloState  `json:"board"`
+	Burndown *TrelloState `json:"burndown,omitempty"`
+	// the points of the cards in the processed lists per member
+	MemberPoints map[string]int `json:"member_points"`
+}
+
+// Reconcile compares the first nLists lists of the board with the Jira sprint, nothing is changed on
+// either side. A negative sprintId stands for the active sprint, burndown may be nil.
+func Reconcile(nLists int, sprintId int, burndown *Burndown) (*ReconcileReport, error) {
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return nil, err
+	}
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		return nil, err
+	}
+	if sprintId < 0 {
+		sprintId = xapJira.ActiveSprint.ID
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ReadRules()
+	if err != nil {
+		return nil, err
+	}
+	directory, err := LoadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	pulls, err := ReadPullRequestLinks()
+	if err != nil {
+		return nil, err
+	}
+	codeComplete := ReadConfig().GitHub.CodeComplete
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		return nil, err
+	}
+	trelloLists, err := board.Lists()
+	if err != nil {
+		return nil, err
+	}
+	issues, err := xapJira.SearchSummaries(fmt.Sprintf("Sprint=%d", sprintId))
+	if err != nil {
+		return nil, err
+	}
+	issueByKey := map[string]IssueSummary{}
+	for _, issue := range issues {
+		issueByKey[issue.Key] = issue
+	}
+
+	report := &ReconcileReport{Time: time.Now(), SprintId: sprintId, Lists: nLists, MemberPoints: map[string]int{}}
+	cardKeys := map[string]bool{}
+	for index, aList := range trelloLists {
+		cards, err := aList.Cards()
+		if err != nil {
+			return nil, err
+		}
+		role := ""
+		if index < len(listRoles) {
+			role = listRoles[index]
+		}
+		for _, card := range cards {
+			key := linkedIssueKey(links, card)
+			item := ReconcileItem{CardId: card.Id, CardName: card.Name, CardUrl: card.Url, List: aList.Name,
+				IssueKey: key, TrelloPoints: points(card.Name), TrelloStatus: role, Members: directory.TrelloNames(card.IdMembers)}
+			complete, pending := pulls.CodeComplete(card.Id)
+			for _, pull := range pending {
+				if !complete {
+					item.PullRequests = append(item.PullRequests, pull.Name())
+				}
+			}
+			if (role == "Done" && !complete) || (role == "InProgress" && complete && len(pulls.ByCard(card.Id)) != 0) {
+				report.CodeMismatches = append(report.CodeMismatches, item)
+			}
+			// the burndown counts the card by the same rule
+			counted := role
+			if role == "Done" && !complete && codeComplete {
+				counted = "InProgress"
+			}
+			switch counted {
+			case "Done":
+				report.Board.Done += item.TrelloPoints
+			case "InProgress":
+				report.Board.InProgress += item.TrelloPoints
+			case "Planned":
+				report.Board.Planned += item.TrelloPoints
+			}
+			if index < nLists {
+				for _, member := range item.Members {
+					report.MemberPoints[member] += item.TrelloPoints
+				}
+			}
+			if nLists <= index {
+				if key != "" {
+					report.CardsOutsideLists = append(report.CardsOutsideLists, item)
+				}
+				continue
+			}
+			if key == "" {
+				rule, err := rules.Match(xapTrello, card, aList.Name)
+				if err != nil {
+					return nil, err
+				}
+				if rule != nil && rule.IssueType != "" {
+					report.CardsWithoutIssues = append(report.CardsWithoutIssues, item)
+				}
+				continue
+			}
+			cardKeys[key] = true
+			issue, ok := issueByKey[key]
+			if !ok {
+				// the next Trello2Jira run moves it into the sprint
+				report.CardsWithoutIssues = append(report.CardsWithoutIssues, item)
+				continue
+			}
+			item.JiraPoints, item.JiraStatus = issue.Points, issue.Status
+			if float64(item.TrelloPoints) != issue.Points {
+				report.PointsMismatches = append(report.PointsMismatches, item)
+			}
+			if expected, ok := roleStatusCategory[role]; ok && expected != issue.StatusCategory {
+				report.StatusMismatches = append(report.StatusMismatches, item)
+			}
+		}
+	}
+	for _, issue := range issues {
+		if !cardKeys[issue.Key] {
+			report.IssuesWithoutCards = append(report.IssuesWithoutCards, ReconcileItem{IssueKey: issue.Key,
+				CardName: issue.Summary, JiraPoints: issue.Points, JiraStatus: issue.Status})
+		}
+	}
+	report.Board.Time = report.Time
+	report.Burndown = lastBurndownState(burndown)
+	return report, nil
+}
+
+// linkedIssueKey finds the issue of the card the same way Trello2Jira does, without linking anything.
+func linkedIssueKey(links *LinkRegistry, card trello.Card) string {
+	if link, ok := links.ByCard(card.Id); ok {
+		return link.IssueKey
+	}
+	if key, ok := isAttached(card.Desc); ok {
+		return key
+	}
+	if key, ok := isAttachingRequired(card.Name); ok {
+		return key
+	}
+	return ""
+}
+
+func lastBurndownState(burndown *Burndown) *TrelloState {
+	var last *TrelloState
+	read := func(b *Burndown) error {
+		if 0 < len(b.TrelloEvents) {
+			state := b.TrelloEvents[len(b.TrelloEvents)-1]
+			last = &state
+		}
+		return nil
+	}
+	if burndown != nil {
+		burndown.Exec(read)
+		return last
+	}
+	b := &Burndown{BurnDownData: BurnDownData{Sprint: CurrentSprint()}}
+	if b.Sprint != nil && b.load() == nil {
+		read(b)
+	}
+	return last
+}
+
+func (r *ReconcileReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Reconciliation of sprint %d with the first %d lists at %s\n", r.SprintId, r.Lists, r.Time.Format("2006-01-02 15:04"))
+	section := func(title string, items []ReconcileItem, format func(item ReconcileItem) string) {
+		fmt.Fprintf(w, "\n%s (%d)\n", title, len(items))
+		for _, item := range items {
+			fmt.Fprintf(w, "  %s\n", format(item))
+		}
+	}
+	card := func(item ReconcileItem) string {
+		res := fmt.Sprintf("%-10s %q in %q %s", item.IssueKey, item.CardName, item.List, item.CardUrl)
+		if len(item.Members) != 0 {
+			res += " (" + strings.Join(item.Members, ", ") + ")"
+		}
+		return res
+	}
+	section("Cards without Jira issues in the sprint", r.CardsWithoutIssues, card)
+	section("Jira sprint issues without cards", r.IssuesWithoutCards, func(item ReconcileItem) string {
+		return fmt.Sprintf("%-10s %q %s", item.IssueKey, item.CardName, item.JiraStatus)
+	})
+	section("Mismatched points", r.PointsMismatches, func(item ReconcileItem) string {
+		return fmt.Sprintf("%-10s trello %d, jira %g %q", item.IssueKey, item.TrelloPoints, item.JiraPoints, item.CardName)
+	})
+	section("Mismatched status", r.StatusMismatches, func(item ReconcileItem) string {
+		return fmt.Sprintf("%-10s trello %s, jira %s %q", item.IssueKey, item.TrelloStatus, item.JiraStatus, item.CardName)
+	})
+	section("Linked cards outside the processed lists", r.CardsOutsideLists, card)
+	section("Cards whose list does not match their pull requests", r.CodeMismatches, func(item ReconcileItem) string {
+		pulls := "all merged"
+		if len(item.PullRequests) != 0 {
+			pulls = "not merged " + strings.Join(item.PullRequests, ", ")
+		}
+		return fmt.Sprintf("%-10s %s, %s %q", item.IssueKey, item.TrelloStatus, pulls, item.CardName)
+	})
+
+	fmt.Fprintf(w, "\nPoints per member (%d)\n", len(r.MemberPoints))
+	members := []string{}
+	for member := range r.MemberPoints {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	for _, member := range members {
+		fmt.Fprintf(w, "  %-20s %d\n", member, r.MemberPoints[member])
+	}
+
+	fmt.Fprintf(w, "\nBoard points     done %d, in progress %d, planned %d\n", r.Board.Done, r.Board.InProgress, r.Board.Planned)
+	if r.Burndown != nil {
+		fmt.Fprintf(w, "Burndown points  done %d, in progress %d, planned %d (%s)\n", r.Burndown.Done, r.Burndown.InProgress,
+			r.Burndown.Planned, r.Burndown.Time.Format("2006-01-02 15:04"))
+	} else {
+		fmt.Fprintln(w, "Burndown points  no data")
+	}
+}
@@ -0,0 +1,384 @@
+package xap_trello
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/barakb/go-trello"
+	"github.com/google/go-github/github"
+)
+
+// the rendered notes of every sprint, <sprint>.md
+const RELEASE_NOTES_DIR = "release-notes"
+
+const DEFAULT_CHANGELOG_FILE = "CHANGELOG.md"
+
+// where the notes are published
+const (
+	ReleaseNotesRelease   = "release"
+	ReleaseNotesChangelog = "changelog"
+)
+
+// the kinds of cards, by the issue type of the rule that matches them
+const (
+	ReleaseNoteFeature = "feature"
+	ReleaseNoteBug     = "bug"
+	ReleaseNoteOther   = "other"
+)
+
+// the points and the size of a card name
+var releaseNoteNoisePattern = regexp.MustCompile(`\([0-9]+\)|\{[A-Za-z]+\}`)
+
+var releaseNoteSections = []struct {
+	kind  string
+	title string
+}{
+	{ReleaseNoteFeature, "New features"},
+	{ReleaseNoteBug, "Bug fixes"},
+	{ReleaseNoteOther, "Other changes"},
+}
+
+type ReleaseNotesConfig struct {
+	Enabled bool `json:"enabled"`
+	// owner/repo that gets the notes, they are only written to RELEASE_NOTES_DIR when empty
+	Repository string `json:"repository"`
+	// release for a draft GitHub release, changelog for a commit to File
+	Publish string `json:"publish"`
+	// the tag of the release, <sprint> is replaced, the sprint name when empty
+	Tag string `json:"tag"`
+	// the branch of the changelog commit and the target of the release, the default branch when empty
+	Branch string `json:"branch"`
+	// DEFAULT_CHANGELOG_FILE when empty
+	File string `json:"file"`
+}
+
+// ReleaseNote is a card of the Done list.
+type ReleaseNote struct {
+	Name string `json:"name"`
+	// the card name without its points and rule markers
+	Title        string            `json:"title"`
+	Url          string            `json:"url"`
+	Kind         string            `json:"kind"`
+	Labels       []string          `json:"labels"`
+	Points       int               `json:"points"`
+	IssueKey     string            `json:"issue_key,omitempty"`
+	PullRequests []PullRequestLink `json:"pull_requests"`
+}
+
+// ReleaseNotes are the cards done in a sprint.
+type ReleaseNotes struct {
+	Sprint string        `json:"sprint"`
+	Time   time.Time     `json:"time"`
+	Notes  []ReleaseNote `json:"notes"`
+	// the issues are linked to it
+	jiraUrl string
+}
+
+// CollectReleaseNotes reads the cards of the Done list, the first list of the board, with their Jira issues and
+// pull requests. The sprint is the name of the Done list when empty.
+func CollectReleaseNotes(xapTrello *Trello, sprint string) (*ReleaseNotes, error) {
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return nil, err
+	}
+	pulls, err := ReadPullRequestLinks()
+	if err != nil {
+		return nil, err
+	}
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		return nil, err
+	}
+	lists, err := board.Lists()
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("the board has no Done list")
+	}
+	if sprint == "" {
+		sprint = strings.TrimPrefix(lists[0].Name, doneListName(""))
+	}
+	cards, err := lists[0].Cards()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ReadRules()
+	if err != nil {
+		return nil, err
+	}
+	res := &ReleaseNotes{Sprint: sprint, Time: time.Now(), Notes: []ReleaseNote{}, jiraUrl: jiraConfig().Url}
+	for _, card := range cards {
+		rule, err := rules.Match(xapTrello, card, lists[0].Name)
+		if err != nil {
+			return nil, err
+		}
+		note := ReleaseNote{Name: card.Name, Url: card.Url, Kind: releaseNoteKind(rule), Labels: cardLabels(card),
+			Points: points(card.Name), IssueKey: linkedIssueKey(links, card), PullRequests: pulls.ByCard(card.Id)}
+		note.Title = releaseNoteTitle(card, rule, note.IssueKey)
+		res.Notes = append(res.Notes, note)
+	}
+	return res, nil
+}
+
+// releaseNoteKind is the kind of the issue type of rule, the cards no rule gives an issue are other changes.
+func releaseNoteKind(rule *Rule) string {
+	if rule == nil {
+		return ReleaseNoteOther
+	}
+	switch rule.IssueType {
+	case "Bug":
+		return ReleaseNoteBug
+	case "New Feature":
+		return ReleaseNoteFeature
+	}
+	return ReleaseNoteOther
+}
+
+// releaseNoteTitle drops the points, the size, the issue key and the marker the title of rule matches
+// from the card name.
+func releaseNoteTitle(card trello.Card, rule *Rule, issueKey string) string {
+	title := releaseNoteNoisePattern.ReplaceAllString(card.Name, " ")
+	if rule != nil && rule.title != nil {
+		title = rule.title.ReplaceAllString(title, " ")
+	}
+	if issueKey != "" {
+		title = strings.Replace(title, issueKey, " ", -1)
+	}
+	title = strings.Trim(strings.Join(strings.Fields(title), " "), " -:")
+	if title == "" {
+		return card.Name
+	}
+	return title
+}
+
+// Title is the name of the release.
+func (n *ReleaseNotes) Title() string {
+	return fmt.Sprintf("Sprint %s", n.Sprint)
+}
+
+// WriteMarkdown writes the notes under the title, the cards grouped by kind and then by their first label.
+func (n *ReleaseNotes) WriteMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "# %s\n\n", n.Title())
+	n.writeSections(w, 2)
+}
+
+// writeSections writes a heading of level for each kind of card and a heading of level+1 for each label.
+func (n *ReleaseNotes) writeSections(w io.Writer, level int) {
+	if len(n.Notes) == 0 {
+		fmt.Fprintf(w, "Nothing was done.\n\n")
+		return
+	}
+	for _, section := range releaseNoteSections {
+		byLabel := map[string][]ReleaseNote{}
+		labels := []string{}
+		for _, note := range n.Notes {
+			if note.Kind != section.kind {
+				continue
+			}
+			label := ""
+			if 0 < len(note.Labels) {
+				label = note.Labels[0]
+			}
+			if _, ok := byLabel[label]; !ok {
+				labels = append(labels, label)
+			}
+			byLabel[label] = append(byLabel[label], note)
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		// the cards without a label come last
+		sort.Strings(labels)
+		if labels[0] == "" {
+			labels = append(labels[1:], "")
+		}
+		fmt.Fprintf(w, "%s %s\n\n", strings.Repeat("#", level), section.title)
+		for _, label := range labels {
+			if label != "" || 1 < len(labels) {
+				name := label
+				if name == "" {
+					name = "General"
+				}
+				fmt.Fprintf(w, "%s %s\n\n", strings.Repeat("#", level+1), name)
+			}
+			for _, note := range byLabel[label] {
+				n.writeNote(w, note)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func (n *ReleaseNotes) writeNote(w io.Writer, note ReleaseNote) {
+	title := note.Title
+	if note.Url != "" {
+		title = fmt.Sprintf("[%s](%s)", title, note.Url)
+	}
+	refs := []string{}
+	if note.IssueKey != "" {
+		refs = append(refs, fmt.Sprintf("[%[1]s](%[2]s/browse/%[1]s)", note.IssueKey, n.jiraUrl))
+	}
+	for _, pull := range note.PullRequests {
+		if pull.State == PullRequestClosed {
+			continue
+		}
+		refs = append(refs, fmt.Sprintf("[%s](%s)", pull.Name(), pull.Url))
+	}
+	if len(refs) == 0 {
+		fmt.Fprintf(w, "- %s\n", title)
+		return
+	}
+	fmt.Fprintf(w, "- %s (%s)\n", title, strings.Join(refs, ", "))
+}
+
+// Markdown returns the notes as written by WriteMarkdown.
+func (n *ReleaseNotes) Markdown() string {
+	buf := &bytes.Buffer{}
+	n.WriteMarkdown(buf)
+	return buf.String()
+}
+
+// Save writes the notes to RELEASE_NOTES_DIR/<sprint>.md and returns the path.
+func (n *ReleaseNotes) Save() (string, error) {
+	if err := os.MkdirAll(RELEASE_NOTES_DIR, 0777); err != nil {
+		return "", err
+	}
+	path := filepath.Join(RELEASE_NOTES_DIR, pageName(n.Sprint)+".md")
+	return path, ioutil.WriteFile(path, []byte(n.Markdown()), 0666)
+}
+
+// PublishReleaseNotes saves the notes and publishes them as configured, it returns where they were published.
+// Publishing the same sprint again updates its draft release, or leaves the changelog as it is.
+func PublishReleaseNotes(notes *ReleaseNotes, config ReleaseNotesConfig) (string, error) {
+	path, err := notes.Save()
+	if err != nil {
+		return "", err
+	}
+	if config.Publish == "" {
+		return path, nil
+	}
+	if config.Publish != ReleaseNotesRelease && config.Publish != ReleaseNotesChangelog {
+		return "", fmt.Errorf("unknown release notes publish %q, expected %s or %s", config.Publish, ReleaseNotesRelease, ReleaseNotesChangelog)
+	}
+	owner, repo := splitRepository(config.Repository)
+	if owner == "" || repo == "" {
+		return "", fmt.Errorf("bad release notes repository %q, expected owner/repo", config.Repository)
+	}
+	client, err := GitHubClient()
+	if err != nil {
+		return "", err
+	}
+	if config.Publish == ReleaseNotesRelease {
+		return publishDraftRelease(client, owner, repo, notes, config)
+	}
+	return commitChangelog(client, owner, repo, notes, config)
+}
+
+// publishDraftRelease creates the draft release of the sprint, or updates the draft of a previous run. A
+// published release is left as it is.
+func publishDraftRelease(client *github.Client, owner, repo string, notes *ReleaseNotes, config ReleaseNotesConfig) (string, error) {
+	ctx := context.Background()
+	tag := notes.Sprint
+	if config.Tag != "" {
+		tag = strings.Replace(config.Tag, "<sprint>", notes.Sprint, -1)
+	}
+	body := &bytes.Buffer{}
+	notes.writeSections(body, 2)
+	releases, _, err := client.Repositories.ListReleases(ctx, owner, repo, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return "", err
+	}
+	for _, release := range releases {
+		if release.GetTagName() != tag && release.GetName() != notes.Title() {
+			continue
+		}
+		if !release.GetDraft() {
+			log.Printf("Release %s of %s/%s is published, its notes are not changed\n", tag, owner, repo)
+			return release.GetHTMLURL(), nil
+		}
+		release.Body = github.String(body.String())
+		edited, _, err := client.Repositories.EditRelease(ctx, owner, repo, release.GetID(), release)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Updated the draft release %s of %s/%s\n", tag, owner, repo)
+		return edited.GetHTMLURL(), nil
+	}
+	release := &github.RepositoryRelease{TagName: github.String(tag), Name: github.String(notes.Title()),
+		Body: github.String(body.String()), Draft: github.Bool(true)}
+	if config.Branch != "" {
+		release.TargetCommitish = github.String(config.Branch)
+	}
+	created, _, err := client.Repositories.CreateRelease(ctx, owner, repo, release)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created the draft release %s of %s/%s\n", tag, owner, repo)
+	return created.GetHTMLURL(), nil
+}
+
+// commitChangelog adds the notes on top of the changelog, below its title, unless the sprint is there already.
+func commitChangelog(client *github.Client, owner, repo string, notes *ReleaseNotes, config ReleaseNotesConfig) (string, error) {
+	ctx := context.Background()
+	file := config.File
+	if file == "" {
+		file = DEFAULT_CHANGELOG_FILE
+	}
+	content, sha := "", ""
+	current, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, file, &github.RepositoryContentGetOptions{Ref: config.Branch})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// the first entry
+	} else if err != nil {
+		return "", err
+	} else if current != nil {
+		if content, err = current.GetContent(); err != nil {
+			return "", err
+		}
+		sha = current.GetSHA()
+	}
+	heading := "## " + notes.Title() + "\n"
+	if strings.HasPrefix(content, heading) || strings.Contains(content, "\n"+heading) {
+		log.Printf("%s of %s/%s has the notes of sprint %s already\n", file, owner, repo, notes.Sprint)
+		return current.GetHTMLURL(), nil
+	}
+	entry := &bytes.Buffer{}
+	entry.WriteString(heading + "\n")
+	notes.writeSections(entry, 3)
+	title := ""
+	if strings.HasPrefix(content, "# ") {
+		end := strings.Index(content, "\n")
+		if end < 0 {
+			end = len(content) - 1
+		}
+		title, content = strings.TrimSpace(content[:end+1])+"\n\n", strings.TrimLeft(content[end+1:], "\n")
+	}
+	options := &github.RepositoryContentFileOptions{Message: github.String(fmt.Sprintf("Release notes of sprint %s", notes.Sprint)),
+		Content: []byte(title + entry.String() + content)}
+	if config.Branch != "" {
+		options.Branch = github.String(config.Branch)
+	}
+	var res *github.RepositoryContentResponse
+	if sha == "" {
+		res, _, err = client.Repositories.CreateFile(ctx, owner, repo, file, options)
+	} else {
+		options.SHA = github.String(sha)
+		res, _, err = client.Repositories.UpdateFile(ctx, owner, repo, file, options)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Committed the notes of sprint %s to %s of %s/%s\n", notes.Sprint, file, owner, repo)
+	return res.Commit.GetHTMLURL(), nil
+}
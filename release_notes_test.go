@@ -0,0 +1,48 @@
+package xap_trello
+
+import "testing"
+
+func collectReleaseNotes(t *testing.T) []ReleaseNote {
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, err := CollectReleaseNotes(xapTrello, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes.Sprint != "12.1-M7" || len(notes.Notes) != 1 {
+		t.Fatalf("collected %+v, expected the card of the Done list of 12.1-M7", notes)
+	}
+	return notes.Notes
+}
+
+func TestReleaseNotesAreClassifiedByTheRules(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	// the default xap-bug rule
+	note := collectReleaseNotes(t)[0]
+	if note.Kind != ReleaseNoteBug || note.Title != "space fails to restart after failover" || note.Points != 5 {
+		t.Errorf("collected %+v, expected a bug without its points and marker", note)
+	}
+
+	err := ToJSONFile(&RuleSet{Rules: []Rule{
+		{Name: "core", Labels: []string{"Core"}, IssueType: "New Feature"},
+		{Name: "bug", Title: "(?i)xap-bug", IssueType: "Bug"},
+	}}, RULES_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note = collectReleaseNotes(t)[0]
+	if note.Kind != ReleaseNoteFeature || note.Title != "xap-bug space fails to restart after failover" {
+		t.Errorf("collected %+v, expected a feature by the label rule", note)
+	}
+
+	if err := ToJSONFile(&RuleSet{Rules: []Rule{{Name: "task", Title: "(?i)xap-bug"}}}, RULES_FILE_NAME); err != nil {
+		t.Fatal(err)
+	}
+	if note = collectReleaseNotes(t)[0]; note.Kind != ReleaseNoteOther {
+		t.Errorf("collected %+v, expected a card without an issue type to be an other change", note)
+	}
+}
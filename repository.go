@@ -0,0 +1,167 @@
+package xap_trello
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// shells out to the git binary, see Git
+	ArchiveBackendExec = "exec"
+	// pure Go, no git binary needed, see GoGit
+	ArchiveBackendGoGit = "go-git"
+)
+
+const (
+	DEFAULT_ARCHIVE_REMOTE = "https://github.com/barakb/imc-sprints.git"
+	DEFAULT_ARCHIVE_BRANCH = "master"
+	// the flat layout of the first archives
+	DEFAULT_ARCHIVE_LAYOUT = "<date>-<sprint>-logs.json"
+	DEFAULT_ARCHIVE_TEAM   = "xap"
+	// the local clone of the archive, the sprint files are written into it
+	ARCHIVE_DIR = "data"
+)
+
+const (
+	// a conflicting rebase is aborted and reported, nothing is pushed (default)
+	ConflictAbort = "abort"
+	// the local version of a conflicting file wins
+	ConflictLocal = "local"
+	// the remote version of a conflicting file wins, the local change to it is dropped
+	ConflictRemote = "remote"
+)
+
+// ArchiveConfig selects the sprint archive repository and how it is accessed.
+type ArchiveConfig struct {
+	// DEFAULT_ARCHIVE_REMOTE when empty, https or ssh (git@host:owner/repo.git)
+	Remote string `json:"remote"`
+	// DEFAULT_ARCHIVE_BRANCH when empty
+	Branch string        `json:"branch"`
+	Auth   GitAuthConfig `json:"auth"`
+	// the path of a sprint file in the archive, <team>, <year>, <month>, <day>, <date> and
+	// <sprint> are replaced, a layout ending with / is a directory holding the default file name
+	Layout string `json:"layout"`
+	// DEFAULT_ARCHIVE_TEAM when empty
+	Team string `json:"team"`
+	// abort, local or remote, abort when empty
+	Conflict string `json:"conflict"`
+	// how often data/ is archived in the background, like "1h", only at rollover when empty
+	Schedule string `json:"schedule"`
+	// exec or go-git, go-git when empty
+	Backend string `json:"backend"`
+	// directory of the git binary used by the exec backend, looked up in PATH when empty
+	GitPath string `json:"git_path"`
+}
+
+// Repository is a local clone of a remote git repository.
+type Repository interface {
+	// Init creates the local repository unless it exists.
+	Init() error
+	// Clone clones the remote into the local directory unless it exists.
+	Clone() error
+	Add(paths ...string) error
+	// Staged reports whether the index differs from the last commit, that is whether there is anything to commit.
+	Staged() (bool, error)
+	Commit(message string) error
+	// Rebase replays the local commits on top of the remote branch, when both sides changed
+	// the same file the conflict policy decides, a *GitConflictError is returned on abort.
+	Rebase() error
+	Push() error
+}
+
+// GitConflictError is returned when local and remote changes can not be combined.
+type GitConflictError struct {
+	Files  []string
+	Detail string
+}
+
+func (e *GitConflictError) Error() string {
+	if len(e.Files) == 0 {
+		return fmt.Sprintf("git conflict: %s", e.Detail)
+	}
+	return fmt.Sprintf("git conflict in %s: %s", strings.Join(e.Files, ", "), e.Detail)
+}
+
+// GitAuthError is returned when the remote rejects the credentials.
+type GitAuthError struct {
+	Remote string
+	Detail string
+}
+
+func (e *GitAuthError) Error() string {
+	return fmt.Sprintf("git authentication to %s failed: %s", e.Remote, e.Detail)
+}
+
+// IsGitConflict reports whether err is a *GitConflictError.
+func IsGitConflict(err error) bool {
+	_, ok := err.(*GitConflictError)
+	return ok
+}
+
+// IsGitAuth reports whether err is a *GitAuthError.
+func IsGitAuth(err error) bool {
+	_, ok := err.(*GitAuthError)
+	return ok
+}
+
+// NewArchiveRepository returns the local clone at local of the archive, accessed by the backend selected by config.
+func NewArchiveRepository(config ArchiveConfig, local string) (Repository, error) {
+	remote, branch := config.Remote, config.Branch
+	if remote == "" {
+		remote = DEFAULT_ARCHIVE_REMOTE
+	}
+	if branch == "" {
+		branch = DEFAULT_ARCHIVE_BRANCH
+	}
+	policy := config.Conflict
+	switch policy {
+	case "":
+		policy = ConflictAbort
+	case ConflictAbort, ConflictLocal, ConflictRemote:
+	default:
+		return nil, fmt.Errorf("unknown archive conflict policy %q, expected %s, %s or %s", policy, ConflictAbort, ConflictLocal, ConflictRemote)
+	}
+	credentials, err := NewGitCredentials(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Backend {
+	case "", ArchiveBackendGoGit:
+		repo := NewGoGitRepository(local, remote, branch, credentials)
+		repo.policy = policy
+		return repo, nil
+	case ArchiveBackendExec:
+		git := NewGitRepository(local, remote, branch, credentials)
+		git.policy = policy
+		if config.GitPath != "" {
+			git.path = strings.TrimSuffix(config.GitPath, "/") + "/"
+		}
+		return git, nil
+	}
+	return nil, fmt.Errorf("unknown archive backend %q, expected %s or %s", config.Backend, ArchiveBackendExec, ArchiveBackendGoGit)
+}
+
+// ArchivePath returns the path of the file of sprint in the archive, relative to the root of the repository.
+func ArchivePath(config ArchiveConfig, sprint *Sprint) string {
+	layout, team := config.Layout, config.Team
+	if layout == "" {
+		layout = DEFAULT_ARCHIVE_LAYOUT
+	}
+	if strings.HasSuffix(layout, "/") {
+		layout += DEFAULT_ARCHIVE_LAYOUT
+	}
+	if team == "" {
+		team = DEFAULT_ARCHIVE_TEAM
+	}
+	start := sprint.Start
+	replacer := strings.NewReplacer(
+		"<team>", team,
+		"<year>", fmt.Sprintf("%d", start.Year()),
+		"<month>", fmt.Sprintf("%02d", start.Month()),
+		"<day>", fmt.Sprintf("%02d", start.Day()),
+		"<date>", fmt.Sprintf("%d-%02d-%02d", start.Year(), start.Month(), start.Day()),
+		"<sprint>", sprint.Name,
+	)
+	return filepath.ToSlash(filepath.Clean(replacer.Replace(layout)))
+}
@@ -0,0 +1,35 @@
+package xap_trello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchivePathLayouts(t *testing.T) {
+	sprint := &Sprint{Name: "12.1-M7", Start: time.Date(2016, 11, 27, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		layout, team, expected string
+	}{
+		{"", "", "2016-11-27-12.1-M7-logs.json"},
+		{"<team>/<year>/", "", "xap/2016/2016-11-27-12.1-M7-logs.json"},
+		{"<team>/<year>/<month>/<sprint>.json", "insightedge", "insightedge/2016/11/12.1-M7.json"},
+		{"<year>-<month>-<day>/<sprint>.json", "", "2016-11-27/12.1-M7.json"},
+		{"./sprints//<date>.json", "", "sprints/2016-11-27.json"},
+	}
+	for _, c := range cases {
+		if path := ArchivePath(ArchiveConfig{Layout: c.layout, Team: c.team}, sprint); path != c.expected {
+			t.Errorf("layout %q of team %q is %q, expected %q", c.layout, c.team, path, c.expected)
+		}
+	}
+}
+
+func TestNewArchiveRepositoryChecksThePolicy(t *testing.T) {
+	_, err := NewArchiveRepository(ArchiveConfig{Remote: "/tmp/remote.git", Auth: GitAuthConfig{Token: "x"}, Conflict: "merge"}, "data")
+	if err == nil {
+		t.Errorf("the unknown conflict policy merge is accepted")
+	}
+	_, err = NewArchiveRepository(ArchiveConfig{Remote: "/tmp/remote.git", Auth: GitAuthConfig{Token: "x"}, Backend: "svn"}, "data")
+	if err == nil {
+		t.Errorf("the unknown backend svn is accepted")
+	}
+}
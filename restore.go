@@ -0,0 +1,164 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RestoreFailure is an archived file that could not be read as burndown data.
+type RestoreFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// RestoreReport tells what the startup restore found in the archive.
+type RestoreReport struct {
+	// cloned or pulled
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+	// the sync with the remote failed, the local files were used
+	Error   string           `json:"error,omitempty"`
+	Sprints int              `json:"sprints"`
+	Failed  []RestoreFailure `json:"failed"`
+}
+
+// RestoreArchive brings ARCHIVE_DIR up to date with the archive repository, cloning it into a
+// fresh container, and imports every sprint file into the history store. Files that are not
+// burndown data are reported, a failed sync leaves the local files to work with.
+func RestoreArchive(config ArchiveConfig) (*RestoreReport, error) {
+	report := &RestoreReport{Time: time.Now(), Failed: []RestoreFailure{}}
+	if err := syncArchive(config, report); err != nil {
+		log.Printf("Error %q, while syncing %s with the archive\n", err.Error(), ARCHIVE_DIR)
+		report.Error = err.Error()
+	}
+
+	history, err := ReadHistory()
+	if err != nil {
+		return report, err
+	}
+	if err := importArchive(history, report); err != nil {
+		return report, err
+	}
+	for _, failure := range report.Failed {
+		log.Printf("Skipping %s of the archive: %s\n", failure.File, failure.Error)
+	}
+	log.Printf("Restored %d sprint(s) from the archive, %d file(s) could not be read\n", report.Sprints, len(report.Failed))
+	return report, history.Save()
+}
+
+// importArchive puts every sprint file of ARCHIVE_DIR into history, the other files are reported.
+func importArchive(history *HistoryStore, report *RestoreReport) error {
+	return filepath.Walk(ARCHIVE_DIR, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == ARCHIVE_DIR {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			return nil
+		}
+		file, err := filepath.Rel(ARCHIVE_DIR, path)
+		if err != nil {
+			return err
+		}
+		file = filepath.ToSlash(file)
+		data, err := readBurnDownData(path)
+		if err != nil {
+			report.Failed = append(report.Failed, RestoreFailure{File: file, Error: err.Error()})
+			return nil
+		}
+		history.Put(file, data)
+		report.Sprints++
+		return nil
+	})
+}
+
+// syncArchive clones the archive into a missing or empty ARCHIVE_DIR and pulls it otherwise.
+func syncArchive(config ArchiveConfig, report *RestoreReport) error {
+	git, err := NewArchiveRepository(config, ARCHIVE_DIR)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(ARCHIVE_DIR, ".git")); err == nil {
+		report.Action = "pulled"
+		if err := commitLocalChanges(git); err != nil {
+			return err
+		}
+		return git.Rebase()
+	}
+	files, err := ioutil.ReadDir(ARCHIVE_DIR)
+	if os.IsNotExist(err) || (err == nil && len(files) == 0) {
+		os.Remove(ARCHIVE_DIR)
+		report.Action = "cloned"
+		return git.Clone()
+	}
+	if err != nil {
+		return err
+	}
+	// data saved before the archive was used, it is kept on top of the archived history
+	report.Action = "pulled"
+	if err := git.Init(); err != nil {
+		return err
+	}
+	return git.Rebase()
+}
+
+// commitLocalChanges commits the files of ARCHIVE_DIR saved since the last archive, the rebase
+// resets the files that are not committed.
+func commitLocalChanges(git Repository) error {
+	files := []string{}
+	err := filepath.Walk(ARCHIVE_DIR, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := filepath.Rel(ARCHIVE_DIR, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(file))
+		return nil
+	})
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	if err := git.Add(files...); err != nil {
+		return err
+	}
+	staged, err := git.Staged()
+	if err != nil || !staged {
+		return err
+	}
+	log.Printf("Committing the local changes of %s before syncing with the archive\n", ARCHIVE_DIR)
+	return git.Commit(fmt.Sprintf("Local changes found on restore on %v", time.Now()))
+}
+
+func readBurnDownData(path string) (*BurnDownData, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data := &BurnDownData{}
+	if err := json.Unmarshal(bytes, data); err != nil {
+		return nil, err
+	}
+	if data.Sprint == nil || data.Sprint.Name == "" {
+		return nil, fmt.Errorf("no sprint in the burndown data")
+	}
+	return data, nil
+}
@@ -0,0 +1,65 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func sprintFile(t *testing.T, name string, done int) string {
+	data := BurnDownData{Sprint: &Sprint{Name: name, Start: time.Date(2016, 11, 27, 0, 0, 0, 0, time.UTC)},
+		TrelloEvents: []TrelloState{{Done: done}}}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+func TestRestoreKeepsTheLocalChanges(t *testing.T) {
+	defer inTempDir(t)()
+	remote, err := filepath.Abs("remote.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	config := ArchiveConfig{Remote: remote, Auth: GitAuthConfig{Token: "x"}}
+	other := gitClone(t, remote, "other", "")
+	gitCommit(t, other, map[string]string{"m6.json": sprintFile(t, "12.1-M6", 1), "m7.json": sprintFile(t, "12.1-M7", 0)})
+	if err := other.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreArchive(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive moves on while the server saves without archiving
+	gitCommit(t, other, map[string]string{"m6.json": sprintFile(t, "12.1-M6", 2)})
+	if err := other.Push(); err != nil {
+		t.Fatal(err)
+	}
+	local := sprintFile(t, "12.1-M7", 3)
+	if err := ioutil.WriteFile(filepath.Join(ARCHIVE_DIR, "m7.json"), []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RestoreArchive(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Error != "" || report.Sprints != 2 {
+		t.Errorf("restore reported %+v, expected both sprints", report)
+	}
+	if m7, err := ioutil.ReadFile(filepath.Join(ARCHIVE_DIR, "m7.json")); err != nil || string(m7) != local {
+		t.Errorf("the local save of 12.1-M7 is lost (%v)", err)
+	}
+	if m6, err := ioutil.ReadFile(filepath.Join(ARCHIVE_DIR, "m6.json")); err != nil || string(m6) != sprintFile(t, "12.1-M6", 2) {
+		t.Errorf("the archived 12.1-M6 is not pulled (%v)", err)
+	}
+}
@@ -0,0 +1,333 @@
+package xap_trello
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const ROLLOVER_FILE_NAME = "rollover.json"
+
+const (
+	StepPending = "pending"
+	StepDone    = "done"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+// The steps of a sprint rollover in the order they run.
+const (
+	StepJiraClose     = "jira-close"
+	StepJiraCreate    = "jira-create"
+	StepTrello2Jira   = "trello2jira"
+	StepJiraStart     = "jira-start"
+	StepJiraReport    = "jira-report"
+	StepReleaseNotes  = "release-notes"
+	StepPauseBurndown = "burndown-pause"
+	StepCloseDoneList = "trello-close-done"
+	StepOpenDoneList  = "trello-open-done"
+	StepArchive       = "archive-commit"
+	StepResetBurndown = "burndown-reset"
+	StepPublishPages  = "pages-publish"
+)
+
+// the burndown is paused first so it does not follow the new Jira sprint before the old one is archived
+var rolloverSteps = []string{StepPauseBurndown, StepJiraClose, StepJiraCreate, StepTrello2Jira, StepJiraStart,
+	StepJiraReport, StepReleaseNotes, StepCloseDoneList, StepOpenDoneList, StepArchive, StepResetBurndown, StepPublishPages}
+
+type RolloverStep struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Rollover is the report of moving from one sprint to the next, it is kept in rollover.json so that a
+// failed rollover can be resumed from the step that failed.
+type Rollover struct {
+	Name         string    `json:"name"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	JiraSprintId int       `json:"jira_sprint_id"`
+	// the sprint that is archived
+	Previous *Sprint `json:"previous"`
+	// the report of the previous sprint, what of it was published
+	Report SprintReportParts `json:"report"`
+	// the burndown scan is paused until the burndown is reset, a restarted server keeps it paused
+	BurndownPaused bool `json:"burndown_paused,omitempty"`
+	// where the release notes of the previous sprint were published
+	ReleaseNotes string         `json:"release_notes,omitempty"`
+	Steps        []RolloverStep `json:"steps"`
+}
+
+func (r *Rollover) Done() bool {
+	for _, step := range r.Steps {
+		if step.Status != StepDone && step.Status != StepSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rollover) Failed() bool {
+	for _, step := range r.Steps {
+		if step.Status == StepFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// SprintRollover closes the current sprint and opens the next one in Jira, Trello and the burndown.
+// Burndown is nil when running outside the server, the archive step then works on the saved data.
+type SprintRollover struct {
+	Burndown *Burndown
+	Lists    int
+	sync.Mutex
+}
+
+func NewSprintRollover(burndown *Burndown) *SprintRollover {
+	return &SprintRollover{Burndown: burndown, Lists: 3}
+}
+
+// LastRollover returns the report of the last rollover, nil if there was none.
+func LastRollover() (*Rollover, error) {
+	rollover := &Rollover{}
+	err := FromJSONFile(rollover, ROLLOVER_FILE_NAME)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rollover, nil
+}
+
+func saveRollover(rollover *Rollover) error {
+	bytes, err := json.MarshalIndent(rollover, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ROLLOVER_FILE_NAME, bytes, 0644)
+}
+
+// ErrRolloverDone is returned with the report of a rollover to a sprint that was already completed.
+var ErrRolloverDone = fmt.Errorf("the rollover is already done")
+
+// Run moves to the sprint name, a rollover to the same sprint that did not complete is resumed,
+// steps that are already done are not repeated. A completed rollover is not run again.
+func (s *SprintRollover) Run(name string, start, end time.Time) (*Rollover, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	rollover, err := LastRollover()
+	if err != nil {
+		return nil, err
+	}
+	if rollover != nil && rollover.Name == name && rollover.Done() {
+		return rollover, ErrRolloverDone
+	}
+	if rollover == nil || rollover.Name != name {
+		rollover = &Rollover{Name: name, Start: start, End: end, Previous: CurrentSprint()}
+		for _, step := range rolloverSteps {
+			rollover.Steps = append(rollover.Steps, RolloverStep{Name: step, Status: StepPending})
+		}
+	} else {
+		log.Printf("Resuming rollover to sprint %s\n", name)
+	}
+
+	var xapJira *Jira
+	var xapTrello *Trello
+	for index := range rollover.Steps {
+		step := &rollover.Steps[index]
+		if step.Status == StepDone || step.Status == StepSkipped {
+			continue
+		}
+		log.Printf("Rollover to sprint %s, running step %s\n", name, step.Name)
+		if xapJira == nil {
+			if xapJira, err = CreateXAPJiraOpen(); err != nil {
+				return rollover, err
+			}
+		}
+		if xapTrello == nil {
+			if xapTrello, err = CreateXAPTrello(); err != nil {
+				return rollover, err
+			}
+		}
+		skipped, err := s.runStep(rollover, step.Name, xapJira, xapTrello)
+		step.Time = time.Now()
+		if err != nil {
+			step.Status, step.Error = StepFailed, err.Error()
+		} else if skipped {
+			step.Status, step.Error = StepSkipped, ""
+		} else {
+			step.Status, step.Error = StepDone, ""
+		}
+		if saveErr := saveRollover(rollover); saveErr != nil {
+			log.Printf("Failed to save rollover state, error is: %s\n", saveErr.Error())
+		}
+		if err != nil {
+			return rollover, fmt.Errorf("rollover to sprint %s failed at step %s: %s", name, step.Name, err.Error())
+		}
+	}
+	return rollover, nil
+}
+
+func (s *SprintRollover) runStep(rollover *Rollover, step string, xapJira *Jira, xapTrello *Trello) (skipped bool, err error) {
+	switch step {
+	case StepJiraClose:
+		if xapJira.ActiveSprint.Name == "" || xapJira.ActiveSprint.ID == rollover.JiraSprintId {
+			return true, nil
+		}
+		log.Printf("Closing old sprint %s\n", xapJira.ActiveSprint.Name)
+		_, _, err = xapJira.Client.Board.CloseSprint(fmt.Sprintf("%d", xapJira.ActiveSprint.ID))
+		return false, err
+	case StepJiraCreate:
+		log.Printf("Creating a new sprint %s\n", rollover.Name)
+		sprint, _, err := xapJira.Client.Board.CreateSprint(rollover.Name, rollover.Start, rollover.End, xapJira.MainScrumBoardId)
+		if err != nil {
+			return false, err
+		}
+		rollover.JiraSprintId = sprint.ID
+		return false, nil
+	case StepTrello2Jira:
+		return false, Trello2Jira(s.Lists, rollover.JiraSprintId)
+	case StepJiraStart:
+		log.Printf("Starting sprint %s\n", rollover.Name)
+		_, _, err = xapJira.Client.Board.StartSprint(fmt.Sprintf("%d", rollover.JiraSprintId))
+		return false, err
+	case StepPauseBurndown:
+		if s.Burndown == nil {
+			return true, nil
+		}
+		rollover.BurndownPaused = true
+		return false, s.Burndown.Exec(func(b *Burndown) error {
+			b.setScanPaused(true)
+			return nil
+		})
+	case StepJiraReport:
+		config := ReadConfig().SprintReport
+		if !config.Enabled {
+			return true, nil
+		}
+		status, err := s.previousStatus(rollover)
+		if err != nil {
+			return false, err
+		}
+		// before the Done list is closed
+		cards, err := SummarizeCards(xapTrello, s.Lists)
+		if err != nil {
+			return false, err
+		}
+		if rollover.Report.Issue == "" {
+			rollover.Report.Issue = config.Issue
+		}
+		return false, PublishSprintReport(xapJira, &rollover.Report, status, cards)
+	case StepReleaseNotes:
+		config := ReadConfig().ReleaseNotes
+		if !config.Enabled {
+			return true, nil
+		}
+		sprint := ""
+		if rollover.Previous != nil {
+			sprint = rollover.Previous.Name
+		}
+		// before the Done list is closed
+		notes, err := CollectReleaseNotes(xapTrello, sprint)
+		if err != nil {
+			return false, err
+		}
+		rollover.ReleaseNotes, err = PublishReleaseNotes(notes, config)
+		return false, err
+	case StepCloseDoneList:
+		return false, closeDoneList(xapTrello, rollover.Name)
+	case StepOpenDoneList:
+		return false, openDoneList(xapTrello, rollover.Name)
+	case StepArchive:
+		if rollover.Previous == nil {
+			return false, fmt.Errorf("fail to read current sprint, nothing to archive")
+		}
+		if s.Burndown != nil {
+			archived := false
+			err := s.Burndown.Exec(func(b *Burndown) error {
+				if !b.follows(rollover.Previous) {
+					return nil
+				}
+				archived = true
+				return b.archive()
+			})
+			if archived || err != nil {
+				return false, err
+			}
+		}
+		b := &Burndown{BurnDownData: BurnDownData{Sprint: rollover.Previous}}
+		if err := b.load(); err != nil {
+			return false, err
+		}
+		// the saved data carries the sprint it was saved with
+		b.Sprint = rollover.Previous
+		return false, b.archive()
+	case StepResetBurndown:
+		sprint := Sprint{Name: rollover.Name, Start: rollover.Start, End: rollover.End}
+		err := WriteSprint(sprint)
+		if err != nil || s.Burndown == nil {
+			return false, err
+		}
+		err = s.Burndown.Exec(func(b *Burndown) error {
+			b.resetSprint(&sprint)
+			return nil
+		})
+		if err == nil {
+			rollover.BurndownPaused = false
+		}
+		return false, err
+	case StepPublishPages:
+		config := ReadConfig()
+		if !config.Pages.Enabled {
+			return true, nil
+		}
+		if s.Burndown != nil && s.Burndown.Pages != nil {
+			return false, s.Burndown.Pages.Publish()
+		}
+		// once, without the background publishing
+		config.Pages.Schedule = ""
+		pages, err := NewPagesPublisher(config.Pages, config.Archive)
+		if err != nil {
+			return false, err
+		}
+		return false, pages.Publish()
+	}
+	return false, fmt.Errorf("unknown rollover step %s", step)
+}
+
+// previousStatus is the burndown of the sprint the rollover moves from, the live burndown is used only
+// while it still follows that sprint.
+func (s *SprintRollover) previousStatus(rollover *Rollover) (*SprintStatus, error) {
+	if s.Burndown != nil && rollover.Previous != nil {
+		var status *SprintStatus
+		err := s.Burndown.Exec(func(b *Burndown) error {
+			if b.follows(rollover.Previous) {
+				current := *b.GetSprintStatus()
+				status = &current
+			}
+			return nil
+		})
+		if status != nil || err != nil {
+			return status, err
+		}
+	}
+	if rollover.Previous == nil {
+		return nil, fmt.Errorf("the previous sprint is unknown, no burndown to report")
+	}
+	b := &Burndown{BurnDownData: BurnDownData{Sprint: rollover.Previous}}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	// the saved data carries the sprint it was saved with
+	b.Sprint = rollover.Previous
+	return b.createSprint(b.compressTimeline()), nil
+}
@@ -0,0 +1,151 @@
+package xap_trello
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// withArchiveRemote configures an empty bare repository as the archive remote.
+func withArchiveRemote(t *testing.T) string {
+	remote, err := filepath.Abs("remote.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, &Config{Archive: ArchiveConfig{Remote: remote, Auth: GitAuthConfig{Token: "fake"}}})
+	return remote
+}
+
+// savePreviousSprint saves a scan of the fixture board as the burndown of sprint 12.1-M7.
+func savePreviousSprint(t *testing.T) (Sprint, *Trello) {
+	previous := Sprint{Name: "12.1-M7", Start: time.Date(2016, 11, 27, 0, 0, 0, 0, time.UTC), End: time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)}
+	if err := WriteSprint(previous); err != nil {
+		t.Fatal(err)
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Burndown{Trello: xapTrello, BurnDownData: BurnDownData{Sprint: &previous}}
+	state, err := b.scanOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.TrelloEvents = append(b.TrelloEvents, state)
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+	return previous, xapTrello
+}
+
+// archivedSprint tells if the head of the archive remote holds the data of sprint.
+func archivedSprint(t *testing.T, remote string, sprint *Sprint) bool {
+	repository, err := git.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return false
+	}
+	commit, err := repository.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = commit.File(ArchivePath(ReadConfig().Archive, sprint))
+	return err == nil
+}
+
+func TestRolloverMovesToTheNextSprint(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+	remote := withArchiveRemote(t)
+
+	previous, xapTrello := savePreviousSprint(t)
+
+	start, end := time.Date(2016, 12, 4, 0, 0, 0, 0, time.UTC), time.Date(2016, 12, 15, 0, 0, 0, 0, time.UTC)
+	rollover, err := NewSprintRollover(nil).Run("12.1-M8", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rollover.Done() {
+		t.Errorf("rollover is not done: %+v", rollover.Steps)
+	}
+
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xapJira.ActiveSprint.Name != "12.1-M8" || xapJira.ActiveSprint.ID != rollover.JiraSprintId {
+		t.Errorf("the active sprint is %s, expected 12.1-M8", xapJira.ActiveSprint.Name)
+	}
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists, err := board.Lists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) == 0 || lists[0].Name != doneListName("12.1-M8") {
+		t.Errorf("the first Trello list is not %s", doneListName("12.1-M8"))
+	}
+	if sprint := ReadSprint(); sprint == nil || sprint.Name != "12.1-M8" {
+		t.Errorf("sprint.json is %+v, expected 12.1-M8", sprint)
+	}
+
+	if !archivedSprint(t, remote, &previous) {
+		t.Errorf("the sprint %s is not archived", previous.Name)
+	}
+
+	if _, err := NewSprintRollover(nil).Run("12.1-M8", start, end); err != ErrRolloverDone {
+		t.Errorf("a completed rollover run again returned %v, expected ErrRolloverDone", err)
+	}
+}
+
+func TestRolloverAfterARestartArchivesThePreviousSprint(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+	remote := withArchiveRemote(t)
+	previous, xapTrello := savePreviousSprint(t)
+
+	// a restarted server, paused before the burndown loaded any sprint
+	b := &Burndown{Trello: xapTrello, commands: make(chan BurndownCommand), done: make(chan struct{}), scanPaused: true}
+	go func() {
+		for {
+			select {
+			case <-b.done:
+				return
+			case cmd := <-b.commands:
+				cmd(b)
+			}
+		}
+	}()
+	defer close(b.done)
+
+	start, end := time.Date(2016, 12, 4, 0, 0, 0, 0, time.UTC), time.Date(2016, 12, 15, 0, 0, 0, 0, time.UTC)
+	rollover, err := NewSprintRollover(b).Run("12.1-M8", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rollover.Done() {
+		t.Errorf("rollover is not done: %+v", rollover.Steps)
+	}
+	if !archivedSprint(t, remote, &previous) {
+		t.Errorf("the sprint %s is not archived", previous.Name)
+	}
+	err = b.Exec(func(b *Burndown) error {
+		if b.scanPaused || !b.follows(&Sprint{Name: "12.1-M8"}) {
+			t.Errorf("the burndown is not resumed on the new sprint, paused %v sprint %+v", b.scanPaused, b.Sprint)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
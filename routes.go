@@ -19,6 +19,7 @@ var routes  Routes
 
 func InitRouters(){
 	burndown = NewBurnDown()
+	githubEvents := NewGitHubEventLog(GITHUB_EVENTS_KEPT)
 	routes = Routes{
 		Route{
 			"GET_TIMELINE",
@@ -42,7 +43,109 @@ func InitRouters(){
 			"SAVE",
 			"POST",
 			"/api/sprint/next",
-			CreateNextSprintHandler(burndown),
+			CreateNextSprintHandler(NewSprintRollover(burndown)),
+		},
+		Route{
+			"ROLLOVER",
+			"GET",
+			"/api/sprint/rollover",
+			CreateRolloverStatusHandler(),
+		},
+		Route{
+			"RECONCILE",
+			"GET",
+			"/api/reconcile",
+			CreateReconcileHandler(burndown),
+		},
+		Route{
+			"LINKS",
+			"GET",
+			"/api/links",
+			CreateLinksHandler(),
+		},
+		Route{
+			"LINK",
+			"GET",
+			"/api/links/{id}",
+			CreateLinksHandler(),
+		},
+		Route{
+			"STATUS",
+			"GET",
+			"/api/status",
+			CreateStatusHandler(burndown),
+		},
+		Route{
+			"HISTORY",
+			"GET",
+			"/api/history",
+			CreateHistoryHandler(),
+		},
+		Route{
+			"CARD_PULLS",
+			"GET",
+			"/api/cards/{id}/pulls",
+			CreateCardPullsHandler(),
+		},
+		Route{
+			"SCAN_PULLS",
+			"POST",
+			"/api/pulls/scan",
+			CreatePullRequestScanHandler(),
+		},
+		Route{
+			"BURNDOWN_SVG",
+			"GET",
+			"/api/burndown.svg",
+			CreateBurndownSVGHandler(burndown),
+		},
+		Route{
+			"IMPORT",
+			"POST",
+			"/api/import",
+			CreateImportHandler(),
+		},
+		Route{
+			"JIRA_WEBHOOK",
+			"POST",
+			"/api/webhooks/jira",
+			CreateJiraWebhookHandler(burndown),
+		},
+		Route{
+			"GITHUB_WEBHOOK",
+			"POST",
+			"/api/webhooks/github",
+			CreateGitHubWebhookHandler(githubEvents),
+		},
+		Route{
+			"GITHUB_EVENTS",
+			"GET",
+			"/api/webhooks/github",
+			CreateGitHubEventsHandler(githubEvents),
+		},
+		Route{
+			"GITHUB_LOGIN",
+			"GET",
+			"/api/github/login",
+			CreateGitHubLoginHandler(),
+		},
+		Route{
+			"GITHUB_CALLBACK",
+			"GET",
+			GITHUB_CALLBACK_PATH,
+			CreateGitHubCallbackHandler(),
+		},
+		Route{
+			"GITHUB",
+			"GET",
+			"/api/github",
+			CreateGitHubStatusHandler(),
+		},
+		Route{
+			"RELEASE_NOTES",
+			"GET",
+			"/api/release-notes",
+			CreateReleaseNotesHandler(),
 		},
 		//Route{
 		//	"CFG.ADD.MACHINES",
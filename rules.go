@@ -0,0 +1,261 @@
+package xap_trello
+
+import (
+	"fmt"
+	"github.com/barakb/go-trello"
+	"os"
+	"regexp"
+)
+
+const RULES_FILE_NAME = "rules.json"
+
+// the assignee of a rule that takes the first card member found in the user mapping
+const AssigneeCardMember = "@member"
+
+// Rule maps the cards it matches to a Jira issue, empty conditions match every card. A rule without
+// an issue type matches cards that should not get an issue.
+type Rule struct {
+	Name string `json:"name"`
+	// conditions
+	Title     string   `json:"title"`  // a regexp of the card name
+	Labels    []string `json:"labels"` // the card has all of them
+	List      string   `json:"list"`
+	Checklist *bool    `json:"checklist"`
+	// the issue
+	IssueType string `json:"issue_type"`
+	Priority  string `json:"priority"`
+	Assignee  string `json:"assignee"` // a Jira user, @member by default
+	Badge     string `json:"badge"`
+	// by field name, e.g. {"Fix Version/s": [{"name": "12.1"}]}
+	Fields map[string]interface{} `json:"fields"`
+
+	title *regexp.Regexp
+}
+
+// RuleSet is the content of rules.json, the first rule that matches a card wins.
+type RuleSet struct {
+	// Trello username to Jira user, for members that are not in the identity directory
+	Users map[string]string `json:"users"`
+	Rules []Rule            `json:"rules"`
+
+	directory *IdentityDirectory
+}
+
+var defaultRules = RuleSet{
+	Users: map[string]string{},
+	Rules: []Rule{
+		{Name: "bug", Title: "(?i)xap-bug", IssueType: "Bug", Badge: ":ant:"},
+		{Name: "feature", Title: "(?i)xap-feature", IssueType: "New Feature", Badge: ":bulb:"},
+		{Name: "task", Title: "(?i)xap-task"},
+	},
+}
+
+// ReadRules reads rules.json from the working directory, without the file the xap-bug, xap-feature and
+// xap-task title rules are used.
+func ReadRules() (*RuleSet, error) {
+	rules := &RuleSet{}
+	err := FromJSONFile(rules, RULES_FILE_NAME)
+	if os.IsNotExist(err) {
+		rules = &RuleSet{Users: defaultRules.Users, Rules: append([]Rule{}, defaultRules.Rules...)}
+	} else if err != nil {
+		return nil, fmt.Errorf("error while reading rules from file %s: %s", RULES_FILE_NAME, err.Error())
+	}
+	if rules.Users == nil {
+		rules.Users = map[string]string{}
+	}
+	if rules.directory, err = LoadIdentities(); err != nil {
+		return nil, err
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Badge == "" {
+			rule.Badge = ":memo:"
+		}
+		if rule.Title != "" {
+			if rule.title, err = regexp.Compile(rule.Title); err != nil {
+				return nil, fmt.Errorf("bad title of rule %s: %s", rule.Name, err.Error())
+			}
+		}
+	}
+	return rules, nil
+}
+
+// RuleMatch is the outcome of one rule for a card, Reason tells the first condition that failed.
+type RuleMatch struct {
+	Rule    string `json:"rule"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Explain evaluates the rules in order up to the first match, listName is the list of the card.
+func (rs *RuleSet) Explain(xapTrello *Trello, card trello.Card, listName string) (*Rule, []RuleMatch, error) {
+	matches := []RuleMatch{}
+	hasChecklist := -1
+	for i := range rs.Rules {
+		rule := &rs.Rules[i]
+		reason := ""
+		if rule.title != nil && !rule.title.MatchString(card.Name) {
+			reason = fmt.Sprintf("title does not match %q", rule.Title)
+		} else if missing := missingLabel(card, rule.Labels); missing != "" {
+			reason = fmt.Sprintf("no label %q", missing)
+		} else if rule.List != "" && rule.List != listName {
+			reason = fmt.Sprintf("list is not %q", rule.List)
+		} else if rule.Checklist != nil {
+			if hasChecklist < 0 {
+				checklists, err := xapTrello.Checklists(card.Id)
+				if err != nil {
+					return nil, matches, err
+				}
+				hasChecklist = len(checklists)
+			}
+			if *rule.Checklist != (0 < hasChecklist) {
+				reason = fmt.Sprintf("checklist presence is not %t", *rule.Checklist)
+			}
+		}
+		matches = append(matches, RuleMatch{Rule: rule.Name, Matched: reason == "", Reason: reason})
+		if reason == "" {
+			return rule, matches, nil
+		}
+	}
+	return nil, matches, nil
+}
+
+// Match returns the first rule that matches the card, nil if none does.
+func (rs *RuleSet) Match(xapTrello *Trello, card trello.Card, listName string) (*Rule, error) {
+	rule, _, err := rs.Explain(xapTrello, card, listName)
+	return rule, err
+}
+
+func missingLabel(card trello.Card, labels []string) string {
+	has := map[string]bool{}
+	for _, label := range cardLabels(card) {
+		has[label] = true
+	}
+	for _, label := range labels {
+		if !has[label] {
+			return label
+		}
+	}
+	return ""
+}
+
+// Assignee resolves the Jira assignee of the rule for the card, nil for none. The card members are
+// looked up in the identity directory and then in the users table.
+func (rs *RuleSet) Assignee(xapTrello *Trello, rule *Rule, card trello.Card) (map[string]string, error) {
+	if rule.Assignee != "" && rule.Assignee != AssigneeCardMember {
+		if identity, ok := rs.directory.ByJira(rule.Assignee); ok {
+			return identity.JiraUser(), nil
+		}
+		return map[string]string{"name": rule.Assignee}, nil
+	}
+	for _, memberId := range card.IdMembers {
+		if identity, ok := rs.directory.ByTrello(memberId); ok && identity.JiraUser() != nil {
+			return identity.JiraUser(), nil
+		}
+		username, err := xapTrello.MemberUsername(memberId)
+		if err != nil {
+			return nil, err
+		}
+		if user, ok := rs.Users[username]; ok {
+			return map[string]string{"name": user}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Reporter is the Jira user of the member that created the card, nil when unknown.
+func (rs *RuleSet) Reporter(xapTrello *Trello, card trello.Card) (map[string]string, error) {
+	creator, err := xapTrello.CardCreator(card.Id)
+	if err != nil || creator == "" {
+		return nil, err
+	}
+	if identity, ok := rs.directory.ByTrello(creator); ok {
+		return identity.JiraUser(), nil
+	}
+	return nil, nil
+}
+
+// IssueFields returns the fields the issue of the card gets on top of its type, summary and description.
+func (rs *RuleSet) IssueFields(xapTrello *Trello, rule *Rule, card trello.Card) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	for name, value := range rule.Fields {
+		fields[name] = value
+	}
+	if rule.Priority != "" {
+		fields["priority"] = map[string]string{"name": rule.Priority}
+	}
+	assignee, err := rs.Assignee(xapTrello, rule, card)
+	if err != nil {
+		return nil, err
+	}
+	if assignee != nil {
+		fields["assignee"] = assignee
+	}
+	if _, ok := fields["reporter"]; !ok {
+		reporter, err := rs.Reporter(xapTrello, card)
+		if err != nil {
+			return nil, err
+		}
+		if reporter != nil {
+			fields["reporter"] = reporter
+		}
+	}
+	return fields, nil
+}
+
+var cardUrlPattern = regexp.MustCompile(`trello\.com/c/([^/?#]+)`)
+
+// CardExplanation tells what Trello2Jira does with a card and why.
+type CardExplanation struct {
+	CardId    string                 `json:"card_id"`
+	CardName  string                 `json:"card_name"`
+	List      string                 `json:"list"`
+	Matches   []RuleMatch            `json:"matches"`
+	Rule      string                 `json:"rule,omitempty"`
+	IssueType string                 `json:"issue_type,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	// the issue the card names when no rule matched
+	AttachTo string `json:"attach_to,omitempty"`
+}
+
+// ExplainCard evaluates the rules for the card given by id, short link or url.
+func ExplainCard(cardRef string) (*CardExplanation, error) {
+	if found := cardUrlPattern.FindStringSubmatch(cardRef); found != nil {
+		cardRef = found[1]
+	}
+	rules, err := ReadRules()
+	if err != nil {
+		return nil, err
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		return nil, err
+	}
+	card, err := xapTrello.Card(cardRef)
+	if err != nil {
+		return nil, err
+	}
+	listName, err := xapTrello.ListName(card.IdList)
+	if err != nil {
+		return nil, err
+	}
+	rule, matches, err := rules.Explain(xapTrello, card, listName)
+	if err != nil {
+		return nil, err
+	}
+	res := &CardExplanation{CardId: card.Id, CardName: card.Name, List: listName, Matches: matches}
+	if rule == nil {
+		res.AttachTo, _ = isAttachingRequired(card.Name)
+		return res, nil
+	}
+	res.Rule, res.IssueType = rule.Name, rule.IssueType
+	if rule.IssueType != "" {
+		if res.Fields, err = rules.IssueFields(xapTrello, rule, card); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
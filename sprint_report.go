@@ -0,0 +1,180 @@
+package xap_trello
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"strings"
+)
+
+type SprintReportConfig struct {
+	Enabled bool `json:"enabled"`
+	// the issue that gets the reports, without it a Task issue is created for every sprint
+	Issue string `json:"issue"`
+}
+
+// CardSummary is one card of the board at the end of a sprint.
+type CardSummary struct {
+	Name     string   `json:"name"`
+	List     string   `json:"list"`
+	Points   int      `json:"points"`
+	IssueKey string   `json:"issue_key,omitempty"`
+	Members  []string `json:"members,omitempty"`
+	Url      string   `json:"url"`
+}
+
+// SummarizeCards lists the cards of the first nLists lists of the board.
+func SummarizeCards(xapTrello *Trello, nLists int) ([]CardSummary, error) {
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return nil, err
+	}
+	directory, err := LoadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	board, err := xapTrello.Board("XAP Scrum")
+	if err != nil {
+		return nil, err
+	}
+	trelloLists, err := board.Lists()
+	if err != nil {
+		return nil, err
+	}
+	res := []CardSummary{}
+	for index, aList := range trelloLists {
+		if nLists <= index {
+			break
+		}
+		cards, err := aList.Cards()
+		if err != nil {
+			return nil, err
+		}
+		for _, card := range cards {
+			res = append(res, CardSummary{Name: card.Name, List: aList.Name, Points: points(card.Name),
+				IssueKey: linkedIssueKey(links, card), Members: directory.TrelloNames(card.IdMembers), Url: card.Url})
+		}
+	}
+	return res, nil
+}
+
+// WriteCardsWiki writes the cards as a table in Jira wiki markup.
+func WriteCardsWiki(w io.Writer, cards []CardSummary) {
+	cell := func(s string) string {
+		s = strings.Replace(s, "|", "\\|", -1)
+		if s == "" {
+			return " "
+		}
+		return s
+	}
+	fmt.Fprintln(w, "||Card||List||Points||Issue||Members||")
+	for _, card := range cards {
+		fmt.Fprintf(w, "|[%s|%s]|%s|%d|%s|%s|\n", cell(card.Name), card.Url, cell(card.List), card.Points,
+			cell(card.IssueKey), cell(strings.Join(card.Members, ", ")))
+	}
+}
+
+// RenderBurndownSVG draws the burndown chart of the sprint status, the same lines as the web page.
+func RenderBurndownSVG(status *SprintStatus) []byte {
+	const width, height, margin = 800, 400, 50
+	max := 1.0
+	value := func(v interface{}) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	for _, day := range status.Days {
+		for _, v := range []interface{}{day.Total, day.Top, day.Expected} {
+			if f, ok := value(v); ok && max < f {
+				max = f
+			}
+		}
+	}
+	step := float64(width-2*margin) / float64(len(status.Days)+1)
+	x := func(index int) float64 {
+		return margin + step*float64(index+1)
+	}
+	y := func(v float64) float64 {
+		return height - margin - v*float64(height-2*margin)/max
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", width, height)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(buf, `<text x="%d" y="25" font-size="16">Sprint %s</text>`+"\n", margin, html.EscapeString(status.Name))
+	fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", margin, height-margin, width-margin, height-margin)
+	fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", margin, margin, margin, height-margin)
+	fmt.Fprintf(buf, `<text x="%d" y="%.1f" text-anchor="end">%.0f</text>`+"\n", margin-5, y(max)+4, max)
+	fmt.Fprintf(buf, `<text x="%d" y="%.1f" text-anchor="end">0</text>`+"\n", margin-5, y(0)+4)
+	for index, day := range status.Days {
+		fmt.Fprintf(buf, `<text x="%.1f" y="%d" text-anchor="end" transform="rotate(-45 %.1f %d)">%s</text>`+"\n",
+			x(index), height-margin+15, x(index), height-margin+15, html.EscapeString(day.Name))
+	}
+	line := func(color, dash string, pick func(day Day) interface{}) {
+		points := []string{}
+		for index, day := range status.Days {
+			if v, ok := value(pick(day)); ok {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x(index), y(v)))
+			}
+		}
+		fmt.Fprintf(buf, `<polyline fill="none" stroke="%s" stroke-width="2" stroke-dasharray="%s" points="%s"/>`+"\n",
+			color, dash, strings.Join(points, " "))
+	}
+	line("#3366cc", "", func(day Day) interface{} { return day.Top })
+	line("#dc3912", "", func(day Day) interface{} { return day.Total })
+	line("#ff9900", "6,4", func(day Day) interface{} { return day.Expected })
+	for index, legend := range []struct{ name, color string }{{"Actual", "#3366cc"}, {"Total", "#dc3912"}, {"Expected", "#ff9900"}} {
+		fmt.Fprintf(buf, `<rect x="%d" y="12" width="12" height="12" fill="%s"/><text x="%d" y="22">%s</text>`+"\n",
+			width-260+index*80, legend.color, width-244+index*80, legend.name)
+	}
+	fmt.Fprintln(buf, "</svg>")
+	return buf.Bytes()
+}
+
+// SprintReportParts records what PublishSprintReport published, a retry does not publish a part again.
+type SprintReportParts struct {
+	Issue   string `json:"issue,omitempty"`
+	Chart   bool   `json:"chart,omitempty"`
+	Comment bool   `json:"comment,omitempty"`
+}
+
+// PublishSprintReport attaches the burndown chart to the report issue and comments with the card
+// summary, a new report issue is created when parts has none.
+func PublishSprintReport(xapJira *Jira, parts *SprintReportParts, status *SprintStatus, cards []CardSummary) error {
+	if parts.Issue == "" {
+		key, err := xapJira.CreateIssue("Task", fmt.Sprintf("Sprint %s report", status.Name),
+			fmt.Sprintf("The burndown and the cards of sprint %s.", status.Name), "")
+		if err != nil {
+			return err
+		}
+		parts.Issue = key
+	}
+	if !parts.Chart {
+		err := xapJira.Attach(parts.Issue, fmt.Sprintf("burndown-%s.svg", status.Name), "image/svg+xml", RenderBurndownSVG(status))
+		if err != nil {
+			return err
+		}
+		parts.Chart = true
+	}
+	if !parts.Comment {
+		points := 0
+		for _, card := range cards {
+			points += card.Points
+		}
+		body := &bytes.Buffer{}
+		fmt.Fprintf(body, "h3. Sprint %s\n\n%d cards, %d points.\n\n", status.Name, len(cards), points)
+		WriteCardsWiki(body, cards)
+		if err := xapJira.AddComment(parts.Issue, body.String()); err != nil {
+			return err
+		}
+		parts.Comment = true
+	}
+	log.Printf("Published the report of sprint %s to %s/browse/%s\n", status.Name, xapJira.Url, parts.Issue)
+	return nil
+}
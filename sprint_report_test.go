@@ -0,0 +1,60 @@
+package xap_trello
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// failingTransport answers 500 to the first request whose path ends with suffix.
+type failingTransport struct {
+	base   http.RoundTripper
+	suffix string
+	failed bool
+}
+
+func (f *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !f.failed && strings.HasSuffix(r.URL.Path, f.suffix) {
+		f.failed = true
+		return &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error",
+			Body: ioutil.NopCloser(strings.NewReader("{}")), Header: http.Header{}, Request: r}, nil
+	}
+	return f.base.RoundTrip(r)
+}
+
+func TestPublishSprintReportRetriesOnlyTheMissingParts(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+	failing := &failingTransport{base: http.DefaultTransport, suffix: "/comment"}
+	http.DefaultTransport = failing
+	defer func() { http.DefaultTransport = failing.base }()
+
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := &SprintStatus{Name: "12.1-M7", Days: []Day{{Name: "Sun", Total: 20, Top: 20, Expected: 20}, {Name: "Mon", Total: 20, Top: 12, Expected: 10}}}
+	cards := []CardSummary{{Name: "space fails to restart after failover", Points: 5}}
+	parts := &SprintReportParts{}
+	if err := PublishSprintReport(xapJira, parts, status, cards); err == nil {
+		t.Fatal("the failed comment is not reported")
+	}
+	if parts.Issue == "" || !parts.Chart || parts.Comment {
+		t.Errorf("published %+v, expected the issue and the chart", parts)
+	}
+	if err := PublishSprintReport(xapJira, parts, status, cards); err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := xapJira.Attachments(parts.Issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := xapJira.Comments(parts.Issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || len(comments) != 1 {
+		t.Errorf("%s has %d attachments and %d comments, expected one of each", parts.Issue, len(attachments), len(comments))
+	}
+}
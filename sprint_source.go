@@ -0,0 +1,130 @@
+package xap_trello
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	SprintSourceFile   = "file"   // sprint.json, written by the rollover
+	SprintSourceJira   = "jira"   // the active sprint of the main scrum board
+	SprintSourceStatic = "static" // the sprint section of the config file
+)
+
+// how often the burndown asks its source for the active sprint
+const SPRINT_CHECK_INTERVAL = time.Minute
+
+type SprintConfig struct {
+	Source string `json:"source"`
+	// the static sprint, dates as 2006-01-02
+	Name  string `json:"name"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// SprintSource tells which sprint is active, nil when there is none.
+type SprintSource interface {
+	ActiveSprint() (*Sprint, error)
+}
+
+type FileSprintSource struct {
+	Path string
+}
+
+func (s FileSprintSource) ActiveSprint() (*Sprint, error) {
+	sprint, err := readSprint(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return sprint, err
+}
+
+type JiraSprintSource struct {
+	jira *Jira
+}
+
+func (s *JiraSprintSource) ActiveSprint() (*Sprint, error) {
+	if s.jira == nil {
+		xapOpenJira, err := CreateXAPJiraOpen()
+		if err != nil {
+			return nil, err
+		}
+		s.jira = xapOpenJira
+	}
+	activeSprints, _, err := s.jira.Client.Board.GetAllActiveSprints(fmt.Sprintf("%d", s.jira.MainScrumBoardId))
+	if err != nil {
+		return nil, err
+	}
+	if len(activeSprints) != 1 {
+		return nil, nil
+	}
+	active := activeSprints[0]
+	if active.StartDate == nil || active.EndDate == nil {
+		return nil, fmt.Errorf("active jira sprint %s has no start or end date", active.Name)
+	}
+	return &Sprint{Name: active.Name, Start: *active.StartDate, End: *active.EndDate}, nil
+}
+
+type StaticSprintSource struct {
+	Sprint Sprint
+}
+
+func (s StaticSprintSource) ActiveSprint() (*Sprint, error) {
+	sprint := s.Sprint
+	return &sprint, nil
+}
+
+// set by the -sprint-source flag of the commands
+var sprintSourceOverride string
+
+// SelectSprintSource overrides the source of the config file, "" keeps it.
+func SelectSprintSource(source string) {
+	sprintSourceOverride = source
+}
+
+func NewSprintSource(config SprintConfig) (SprintSource, error) {
+	if sprintSourceOverride != "" {
+		config.Source = sprintSourceOverride
+	}
+	switch config.Source {
+	case "", SprintSourceFile:
+		return FileSprintSource{Path: "sprint.json"}, nil
+	case SprintSourceJira:
+		return &JiraSprintSource{}, nil
+	case SprintSourceStatic:
+		start, err := time.Parse(date_tmpl, config.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(date_tmpl, config.End)
+		if err != nil {
+			return nil, err
+		}
+		return StaticSprintSource{Sprint: Sprint{Name: config.Name, Start: start, End: end}}, nil
+	}
+	return nil, fmt.Errorf("unknown sprint source %q, expected %s, %s or %s", config.Source, SprintSourceFile, SprintSourceJira, SprintSourceStatic)
+}
+
+// CurrentSprint returns the active sprint of the configured source, nil when there is none.
+func CurrentSprint() *Sprint {
+	source, err := NewSprintSource(ReadConfig().Sprint)
+	if err != nil {
+		log.Printf("error while reading sprint source: %s\n", err.Error())
+		return nil
+	}
+	sprint, err := source.ActiveSprint()
+	if err != nil {
+		log.Printf("error while reading active sprint: %s\n", err.Error())
+		return nil
+	}
+	return sprint
+}
+
+func (s *Sprint) sameAs(other *Sprint) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return s.Name == other.Name && s.Start.Equal(other.Start) && s.End.Equal(other.End)
+}
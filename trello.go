@@ -1,8 +1,11 @@
 package xap_trello
 
 import (
+	"encoding/json"
 	"github.com/barakb/go-trello"
+	"net/url"
 	"os"
+	"time"
 )
 
 type Trello struct {
@@ -41,9 +44,133 @@ func (c *Trello) Notifications() ([]trello.Notification, error) {
 	return member.Notifications()
 }
 
+// TrelloComment is a comment action as returned by /cards/{id}/actions?filter=commentCard
+type TrelloComment struct {
+	Id   string    `json:"id"`
+	Date time.Time `json:"date"`
+	Data struct {
+		Text string `json:"text"`
+	} `json:"data"`
+	MemberCreator struct {
+		Id       string `json:"id"`
+		FullName string `json:"fullName"`
+		Username string `json:"username"`
+	} `json:"memberCreator"`
+}
+
+type TrelloAttachment struct {
+	Id   string    `json:"id"`
+	Name string    `json:"name"`
+	Url  string    `json:"url"`
+	Date time.Time `json:"date"`
+}
+
+func (c *Trello) getJSON(resource string, val interface{}) error {
+	body, err := c.Client.Get(resource)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, val)
+}
+
+func (c *Trello) Comments(cardId string) ([]TrelloComment, error) {
+	comments := []TrelloComment{}
+	err := c.getJSON("/cards/"+cardId+"/actions?filter=commentCard&limit=1000", &comments)
+	return comments, err
+}
 
+func (c *Trello) AddComment(cardId, text string) error {
+	_, err := c.Client.Post("/cards/"+cardId+"/actions/comments", url.Values{"text": {text}})
+	return err
+}
 
+func (c *Trello) Attachments(cardId string) ([]TrelloAttachment, error) {
+	attachments := []TrelloAttachment{}
+	err := c.getJSON("/cards/"+cardId+"/attachments", &attachments)
+	return attachments, err
+}
 
+func (c *Trello) AttachLink(cardId, name, link string) error {
+	_, err := c.Client.Post("/cards/"+cardId+"/attachments", url.Values{"name": {name}, "url": {link}})
+	return err
+}
 
+// AddCard creates a card at the bottom of the list.
+func (c *Trello) AddCard(listId, name, desc string) (trello.Card, error) {
+	card := trello.Card{}
+	body, err := c.Client.Post("/cards", url.Values{"idList": {listId}, "name": {name}, "desc": {desc}})
+	if err != nil {
+		return card, err
+	}
+	err = json.Unmarshal(body, &card)
+	return card, err
+}
+
+func (c *Trello) Card(cardId string) (trello.Card, error) {
+	card := trello.Card{}
+	err := c.getJSON("/cards/"+cardId, &card)
+	return card, err
+}
 
+func (c *Trello) SetCardName(cardId, name string) error {
+	_, err := c.Client.Put("/cards/"+cardId, url.Values{"name": {name}})
+	return err
+}
 
+// CardCreator returns the id of the member that created the card, "" when Trello no longer has the action.
+func (c *Trello) SetCardDesc(cardId, desc string) error {
+	_, err := c.Client.Put("/cards/"+cardId, url.Values{"desc": {desc}})
+	return err
+}
+
+// AddMember adds the member to the card, Trello ignores a member the card already has.
+func (c *Trello) AddMember(cardId, memberId string) error {
+	_, err := c.Client.Post("/cards/"+cardId+"/idMembers", url.Values{"value": {memberId}})
+	return err
+}
+
+func (c *Trello) MoveCard(cardId, listId string) error {
+	_, err := c.Client.Put("/cards/"+cardId, url.Values{"idList": {listId}})
+	return err
+}
+
+func (c *Trello) CardCreator(cardId string) (string, error) {
+	actions := []struct {
+		IdMemberCreator string `json:"idMemberCreator"`
+	}{}
+	if err := c.getJSON("/cards/"+cardId+"/actions?filter=createCard", &actions); err != nil {
+		return "", err
+	}
+	if len(actions) == 0 {
+		return "", nil
+	}
+	return actions[0].IdMemberCreator, nil
+}
+
+type TrelloChecklist struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (c *Trello) Checklists(cardId string) ([]TrelloChecklist, error) {
+	checklists := []TrelloChecklist{}
+	err := c.getJSON("/cards/"+cardId+"/checklists", &checklists)
+	return checklists, err
+}
+
+// MemberUsername returns the username of the member with the given id.
+func (c *Trello) MemberUsername(memberId string) (string, error) {
+	member := struct {
+		Username string `json:"username"`
+	}{}
+	err := c.getJSON("/members/"+memberId, &member)
+	return member.Username, err
+}
+
+func (c *Trello) ListName(listId string) (string, error) {
+	list := struct {
+		Name string `json:"name"`
+	}{}
+	err := c.getJSON("/lists/"+listId, &list)
+	return list.Name, err
+}
@@ -32,6 +32,21 @@ func Trello2Jira(nLists int, activeSprintId int) error {
 	if err != nil {
 		return err
 	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		return err
+	}
+	journal, err := OpenJournal(JOURNAL_FILE_NAME)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	rules, err := ReadRules()
+	if err != nil {
+		return err
+	}
+	resumeJournal(xapTrello, xapOpenJira, links, journal, rules, activeSprintId)
+
 	var trelloCardByJiraKey = map[string]trello.Card{}
 	for n, aList := range trelloLists {
 		if nLists <= n {
@@ -44,72 +59,22 @@ func Trello2Jira(nLists int, activeSprintId int) error {
 			return err
 		}
 		for _, card := range cards {
-			if hasBugPattern(card.Name) {
-				if key, ok := isAttached(card.Desc); !ok {
-					key, err := xapOpenJira.CreateBug(card.Name, card.Desc, card.Url)
-					if err != nil {
-						log.Printf("Failed to add jira bug for card %s, error is %s\n", card.Name, err.Error())
-						continue
-					}
-					err = xapOpenJira.AddToSprint(key, activeSprintId)
-					if err != nil {
-						log.Printf("Failed to move bug:%s to sprint %s, error is %s\n", key, xapOpenJira.ActiveSprint.Name, err.Error())
-						continue
-					}
-					newDesc := fmt.Sprintf("[:ant: %[1]s](%s/browse/%[1]s).\n\n", key, xapOpenJira.Url) + card.Desc
-					card.SetDesc(newDesc)
-					trelloCardByJiraKey[key] = card
-					log.Printf("Bug:%q -> %s/browse/%s\n", card.Name, xapOpenJira.Url, key)
-				} else {
-					trelloCardByJiraKey[key] = card
-					err = xapOpenJira.AddToSprint(key, activeSprintId)
-					if err != nil {
-						log.Printf("Failed to move card %s, to current sprint, error is:%s\n", key, err.Error())
-					}
-				}
-			} else if hasFeaturePattern(card.Name) {
-				if key, ok := isAttached(card.Desc); !ok {
-					key, err := xapOpenJira.CreateFeature(card.Name, card.Desc, card.Url)
-					if err != nil {
-						log.Printf("Failed to add jira feature for card %s, error is %s\n", card.Name, err.Error())
-						continue
-					}
-					err = xapOpenJira.AddToSprint(key, activeSprintId)
-					if err != nil {
-						log.Printf("Failed to move bug:%s to sprint %s, error is %s\n", key, xapOpenJira.ActiveSprint.Name, err.Error())
-						continue
-					}
-					newDesc := fmt.Sprintf("[:bulb: %[1]s](%s/browse/%[1]s).\n\n", key, xapOpenJira.Url) + card.Desc
-					card.SetDesc(newDesc)
-					trelloCardByJiraKey[key] = card
-					log.Printf("Feature:%q -> %s/browse/%s\n", card.Name, xapOpenJira.Url, key)
-				} else {
-					trelloCardByJiraKey[key] = card
-					err = xapOpenJira.AddToSprint(key, activeSprintId)
-					if err != nil {
-						log.Printf("Failed to move card %s, to current sprint, error is:%s\n", key, err.Error())
-					}
-				}
-			} else if hasTaskPattern(card.Name) {
-				//todo
-			} else if key, assigned := isAttachingRequired(card.Name); assigned {
-				trelloCardByJiraKey[key] = card
-				if _, ok := isAttached(card.Desc); !ok {
-					err := xapOpenJira.AttachIssueToTrelloCard(key, card.Url)
-					if err != nil {
-						log.Printf("Failed to attach card %s, to issue %s, error is:%s\n", card.Name, key, err.Error())
-						continue
-					}
-					newDesc := fmt.Sprintf("[:link: %[1]s](%s/browse/%[1]s).\n\n", key, xapOpenJira.Url) + card.Desc
-					card.SetDesc(newDesc)
-					log.Printf("Feature:%q (linked)-> %s/browse/%s\n", card.Name, xapOpenJira.Url, key)
-				}
-				err = xapOpenJira.AddToSprint(key, activeSprintId)
-				if err != nil {
-					log.Printf("Failed to move card %s, to current sprint, error is:%s\n", key, err.Error())
-				}
-
+			key, err := linkCard(xapTrello, xapOpenJira, links, journal, rules, card, aList.Name)
+			if err != nil {
+				log.Printf("Failed to link card %s to jira, error is %s\n", card.Name, err.Error())
+			}
+			if key == "" {
+				continue
+			}
+			trelloCardByJiraKey[key] = card
+			moveErr := journal.Step(OpMoveToSprint, card.Id, key, func() error {
+				return xapOpenJira.AddToSprint(key, activeSprintId)
+			})
+			if moveErr != nil {
+				log.Printf("Failed to move card %s, to current sprint, error is:%s\n", key, moveErr.Error())
+				err = moveErr
 			}
+			links.SetSyncState(card.Id, err)
 		}
 	}
 
@@ -120,7 +85,10 @@ func Trello2Jira(nLists int, activeSprintId int) error {
 	for _, issue := range issues {
 		if _, ok := trelloCardByJiraKey[issue.Key]; !ok {
 			fmt.Printf("Jira sprint issue %s is not in first 3 trello lists, moving to backlog\n", issue.Key)
-			_, err := xapOpenJira.Client.Sprint.MoveIssuesToBackLog(issue.Key)
+			err := journal.Step(OpMoveToBacklog, issue.Key, issue.Key, func() error {
+				_, err := xapOpenJira.Client.Sprint.MoveIssuesToBackLog(issue.Key)
+				return err
+			})
 			if err != nil {
 				log.Printf("Failed to move issue %s to backlog, error is: %s\n", issue.Key, err.Error())
 			}
@@ -146,12 +114,211 @@ func Trello2Jira(nLists int, activeSprintId int) error {
 		}
 		*/
 	}
+	config := ReadConfig()
+	if len(config.LabelMappings) != 0 {
+		for key, card := range trelloCardByJiraKey {
+			key, card := key, card
+			err := journal.Step(OpApplyLabels, card.Id, key, func() error {
+				return xapOpenJira.ApplyLabelMapping(key, cardLabels(card), config.LabelMappings)
+			})
+			if err != nil {
+				log.Printf("Failed to apply label mapping of card %s to issue %s, error is:%s\n", card.Name, key, err.Error())
+			}
+		}
+	}
+	MirrorDiscussion(xapTrello, xapOpenJira, trelloCardByJiraKey)
 	return nil
 }
 
+// linkCard returns the key of the issue linked to the card, creating the issue when a rule maps the
+// card to an issue type or attaching the issue the card names. The link registry is the source of
+// truth, the description badge is only read for cards that were linked before the registry existed.
+func linkCard(xapTrello *Trello, xapOpenJira *Jira, links *LinkRegistry, journal *Journal, rules *RuleSet, card trello.Card, listName string) (string, error) {
+	link, linked := links.ByCard(card.Id)
+	if !linked {
+		if key, ok := isAttached(card.Desc); ok {
+			link = Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: key, SyncState: LinkLinked}
+			if err := links.Put(link); err != nil {
+				return "", err
+			}
+			linked = true
+		}
+	}
+	if linked && hasBadge(card.Desc, link.IssueKey) {
+		// nothing left to do, pending field updates are retried from the journal
+		return link.IssueKey, nil
+	}
+
+	rule, err := rules.Match(xapTrello, card, listName)
+	if err != nil {
+		return link.IssueKey, err
+	}
+	var badge string
+	if rule != nil && rule.IssueType != "" {
+		badge = rule.Badge
+	} else if rule != nil {
+		// a rule for cards without issues
+		return link.IssueKey, nil
+	} else if key, assigned := isAttachingRequired(card.Name); assigned {
+		badge = ":link:"
+		if !linked {
+			err := journal.Step(OpAttachIssue, card.Id, key, func() error {
+				return xapOpenJira.AttachIssueToTrelloCard(key, card.Url)
+			})
+			if err != nil {
+				return "", err
+			}
+			link = Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: key, SyncState: LinkLinked}
+			if err := links.Put(link); err != nil {
+				return "", err
+			}
+			linked = true
+			log.Printf("Feature:%q (linked)-> %s/browse/%s\n", card.Name, xapOpenJira.Url, key)
+		}
+	} else {
+		return link.IssueKey, nil
+	}
+
+	if !linked {
+		// an earlier run may have created the issue and crashed before recording it
+		key, err := xapOpenJira.FindIssueByCard(card.Url)
+		if err != nil {
+			return "", err
+		}
+		if key != "" {
+			log.Printf("Found existing issue %s of card %q\n", key, card.Name)
+		} else {
+			if err := journal.Begin(OpCreateIssue, card.Id, ""); err != nil {
+				return "", err
+			}
+			if key, err = xapOpenJira.CreateIssue(rule.IssueType, card.Name, card.Desc, card.Url); key == "" {
+				return "", err
+			} else if err != nil {
+				log.Printf("Created issue %s of card %q with an error: %s\n", key, card.Name, err.Error())
+			}
+			log.Printf("%s:%q (%s)-> %s/browse/%s\n", rule.IssueType, card.Name, rule.Name, xapOpenJira.Url, key)
+		}
+		link = Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: key, SyncState: LinkCreated}
+		if err := links.Put(link); err != nil {
+			return "", err
+		}
+		if err := journal.Done(OpCreateIssue, card.Id, key); err != nil {
+			return key, err
+		}
+		err = journal.Step(OpSetFields, card.Id, key, func() error {
+			fields, err := rules.IssueFields(xapTrello, rule, card)
+			if err != nil {
+				return err
+			}
+			return xapOpenJira.SetFields(key, fields)
+		})
+		if err != nil {
+			return key, fmt.Errorf("fail to set the fields of rule %s: %s", rule.Name, err.Error())
+		}
+	}
+	if !hasBadge(card.Desc, link.IssueKey) {
+		newDesc := fmt.Sprintf("[%[1]s %[2]s](%[3]s/browse/%[2]s).\n\n", badge, link.IssueKey, xapOpenJira.Url) + card.Desc
+		err := journal.Step(OpSetDesc, card.Id, link.IssueKey, func() error {
+			return card.SetDesc(newDesc)
+		})
+		if err != nil {
+			return link.IssueKey, fmt.Errorf("fail to add link badge of %s to the card: %s", link.IssueKey, err.Error())
+		}
+	}
+	return link.IssueKey, nil
+}
+
+// resumeJournal finishes the steps a crashed run left behind before the cards are linked again, a cut
+// create step is completed by finding the issue of the card instead of creating another one. A step
+// that fails again stays pending for the next run.
+func resumeJournal(xapTrello *Trello, xapOpenJira *Jira, links *LinkRegistry, journal *Journal, rules *RuleSet, activeSprintId int) {
+	for _, op := range []string{OpCreateIssue, OpSetFields, OpAttachIssue, OpSetDesc, OpMoveToSprint, OpMoveToBacklog, OpApplyLabels} {
+		for _, entry := range journal.Pending(op) {
+			log.Printf("Resuming %s of %s left by a previous run\n", entry.Op, entry.Target)
+			key, err := replayJournalEntry(xapTrello, xapOpenJira, links, journal, rules, activeSprintId, entry)
+			if err == nil {
+				err = journal.Done(entry.Op, entry.Target, key)
+			}
+			if err != nil {
+				log.Printf("Failed to resume %s of %s, error is: %s\n", entry.Op, entry.Target, err.Error())
+			}
+		}
+	}
+}
+
+// replayJournalEntry runs the step of entry again and returns the key of its issue.
+func replayJournalEntry(xapTrello *Trello, xapOpenJira *Jira, links *LinkRegistry, journal *Journal, rules *RuleSet, activeSprintId int, entry JournalEntry) (string, error) {
+	key := entry.IssueKey
+	switch entry.Op {
+	case OpMoveToSprint:
+		return key, xapOpenJira.AddToSprint(key, activeSprintId)
+	case OpMoveToBacklog:
+		_, err := xapOpenJira.Client.Sprint.MoveIssuesToBackLog(key)
+		return key, err
+	}
+	card, err := xapTrello.Card(entry.Target)
+	if err != nil {
+		return key, err
+	}
+	switch entry.Op {
+	case OpCreateIssue:
+		if key, err = xapOpenJira.FindIssueByCard(card.Url); err != nil || key == "" {
+			// not created, the card is linked again by this run
+			return key, err
+		}
+		log.Printf("Found existing issue %s of card %q\n", key, card.Name)
+		if err := links.Put(Link{CardId: card.Id, CardUrl: card.Url, CardName: card.Name, IssueKey: key, SyncState: LinkCreated}); err != nil {
+			return key, err
+		}
+		// the fields were never set, the card is linked now so only the journal sets them
+		return key, journal.Begin(OpSetFields, card.Id, key)
+	case OpSetFields:
+		rule, err := matchCard(xapTrello, rules, card)
+		if err != nil || rule == nil || rule.IssueType == "" {
+			return key, err
+		}
+		fields, err := rules.IssueFields(xapTrello, rule, card)
+		if err != nil {
+			return key, err
+		}
+		return key, xapOpenJira.SetFields(key, fields)
+	case OpAttachIssue:
+		return key, xapOpenJira.AttachIssueToTrelloCard(key, card.Url)
+	case OpSetDesc:
+		if hasBadge(card.Desc, key) {
+			return key, nil
+		}
+		rule, err := matchCard(xapTrello, rules, card)
+		if err != nil {
+			return key, err
+		}
+		badge := ":link:"
+		if rule != nil && rule.IssueType != "" {
+			badge = rule.Badge
+		}
+		return key, card.SetDesc(fmt.Sprintf("[%[1]s %[2]s](%[3]s/browse/%[2]s).\n\n", badge, key, xapOpenJira.Url) + card.Desc)
+	case OpApplyLabels:
+		return key, xapOpenJira.ApplyLabelMapping(key, cardLabels(card), ReadConfig().LabelMappings)
+	}
+	return key, fmt.Errorf("unknown journal step %s", entry.Op)
+}
+
+// matchCard is the rule of the card in the list it is in now.
+func matchCard(xapTrello *Trello, rules *RuleSet, card trello.Card) (*Rule, error) {
+	listName, err := xapTrello.ListName(card.IdList)
+	if err != nil {
+		return nil, err
+	}
+	return rules.Match(xapTrello, card, listName)
+}
+
+func hasBadge(desc, key string) bool {
+	return strings.Contains(desc, "/browse/"+key+")")
+}
+
 func isAttached(desc string) (string, bool) {
 	//[:ant: XAP-13053](https://xap-issues.atlassian.net/browse/XAP-13053).
-	re := regexp.MustCompile(`\[.*XAP\-(\d+)\]\s*\(https://xap\-issues.atlassian.net/browse/XAP\-(\d+)\)`)
+	re := regexp.MustCompile(`\[:[a-z_]+: ([A-Z][A-Z0-9]*\-\d+)\]\s*\(https://[^\s)]+/browse/([A-Z][A-Z0-9]*\-\d+)\)`)
 	found := re.FindStringSubmatch(desc)
 	if found != nil {
 		return found[1], len(found) == 3 && found[1] == found[2]
@@ -169,14 +336,15 @@ func isAttachingRequired(name string) (string, bool) {
 	return "", false
 }
 
-func hasBugPattern(name string) bool {
-	return strings.Contains(strings.ToLower(name), "xap-bug")
-}
-func hasFeaturePattern(name string) bool {
-	return strings.Contains(strings.ToLower(name), "xap-feature")
-}
-func hasTaskPattern(name string) bool {
-	return strings.Contains(strings.ToLower(name), "xap-task")
+func cardLabels(card trello.Card) []string {
+	labels := []string{}
+	for _, label := range card.Labels {
+		if label.Name != "" {
+			labels = append(labels, label.Name)
+		}
+	}
+	return labels
 }
 
 
+
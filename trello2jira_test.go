@@ -0,0 +1,255 @@
+package xap_trello
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrello2JiraLinksTheCardsOfTheBoard(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		// linked by the badge of its description
+		"5800000000000000000000d1": "GS-101",
+		// the key in its name
+		"5800000000000000000000d3": "XAP-13053",
+	}
+	for cardId, key := range expected {
+		if link, ok := links.ByCard(cardId); !ok || link.IssueKey != key {
+			t.Errorf("card %s is linked to %q, expected %s", cardId, link.IssueKey, key)
+		}
+	}
+	// the xap-feature and xap-bug cards get new issues, the card without a marker none
+	for _, cardId := range []string{"5800000000000000000000d2", "5800000000000000000000d4"} {
+		link, ok := links.ByCard(cardId)
+		if !ok {
+			t.Errorf("card %s is not linked", cardId)
+			continue
+		}
+		card, err := xapTrello.Card(cardId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key, err := xapJira.FindIssueByCard(card.Url); err != nil || key != link.IssueKey {
+			t.Errorf("the issue of card %s is %q, expected %s (%v)", cardId, key, link.IssueKey, err)
+		}
+		if !hasBadge(card.Desc, link.IssueKey) {
+			t.Errorf("card %s has no badge of %s: %q", cardId, link.IssueKey, card.Desc)
+		}
+	}
+	if link, ok := links.ByCard("5800000000000000000000d5"); ok {
+		t.Errorf("card without a rule is linked to %s", link.IssueKey)
+	}
+
+	sprintIssues, err := xapJira.GetAllSprintIssues(xapJira.ActiveSprint.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, issue := range sprintIssues {
+		keys = append(keys, issue.Key)
+	}
+	// GS-102 is not on the board, it goes back to the backlog
+	if inSprint := strings.Join(keys, ","); len(keys) != 4 || strings.Contains(inSprint, "GS-102") || !strings.Contains(inSprint, "XAP-13053") {
+		t.Errorf("the sprint has issues %s, expected the 4 linked issues", inSprint)
+	}
+}
+
+func countIssues(t *testing.T, xapJira *Jira) int {
+	issues, err := xapJira.SearchSummaries("project = GS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(issues)
+}
+
+func TestTrello2JiraRunsAgainWithoutChanges(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := countIssues(t, xapJira)
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := links.All()
+
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	if again := countIssues(t, xapJira); again != issues {
+		t.Errorf("the second run created %d issues", again-issues)
+	}
+	after := links.All()
+	if len(after) != len(before) {
+		t.Fatalf("the second run has %d links, expected %d", len(after), len(before))
+	}
+	for _, link := range before {
+		if again, _ := links.ByCard(link.CardId); again.IssueKey != link.IssueKey {
+			t.Errorf("card %s moved from %s to %s", link.CardId, link.IssueKey, again.IssueKey)
+		}
+	}
+}
+
+func TestTrello2JiraResumesACutCreate(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xapTrello, err := CreateXAPTrello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a run that crashed after the issue of the card was created
+	card, err := xapTrello.Card("5800000000000000000000d2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := xapJira.CreateIssue("New Feature", card.Name, card.Desc, card.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(JOURNAL_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Begin(OpCreateIssue, card.Id, ""); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	issues := countIssues(t, xapJira)
+
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := links.ByCard(card.Id); link.IssueKey != key {
+		t.Errorf("card is linked to %q, expected the issue %s of the cut run", link.IssueKey, key)
+	}
+	// only the xap-bug card gets a new issue
+	if created := countIssues(t, xapJira) - issues; created != 1 {
+		t.Errorf("the run created %d issues, expected 1", created)
+	}
+	if journal, err = OpenJournal(JOURNAL_FILE_NAME); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	for _, op := range []string{OpCreateIssue, OpSetFields, OpSetDesc} {
+		if pending := journal.Pending(op); len(pending) != 0 {
+			t.Errorf("%s is still pending: %+v", op, pending)
+		}
+	}
+}
+
+// countingTransport counts the requests whose path contains part.
+type countingTransport struct {
+	base  http.RoundTripper
+	part  string
+	count int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.Contains(r.URL.Path, c.part) {
+		c.count++
+	}
+	return c.base.RoundTrip(r)
+}
+
+func TestTrello2JiraDoesNotMatchLinkedCards(t *testing.T) {
+	_, restore := withFakeBackend(t)
+	defer restore()
+	checklist := true
+	err := ToJSONFile(&RuleSet{Rules: []Rule{
+		{Name: "bug", Title: "(?i)xap-bug", Checklist: &checklist, IssueType: "Bug", Priority: "Major"},
+		{Name: "feature", Title: "(?i)xap-feature", IssueType: "New Feature"},
+	}}, RULES_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checklists := &countingTransport{base: http.DefaultTransport, part: "/checklists"}
+	http.DefaultTransport = checklists
+	defer func() { http.DefaultTransport = checklists.base }()
+
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	if checklists.count == 0 {
+		t.Fatalf("the checklist rule was not evaluated")
+	}
+	links, err := DefaultLinkRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bug, ok := links.ByCard("5800000000000000000000d4")
+	if !ok {
+		t.Fatalf("the xap-bug card with a checklist is not linked")
+	}
+	// a run that failed to set the fields of the bug
+	xapJira, err := CreateXAPJiraOpen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xapJira.SetFields(bug.IssueKey, map[string]interface{}{"priority": map[string]string{"name": "Minor"}}); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(JOURNAL_FILE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Begin(OpSetFields, bug.CardId, bug.IssueKey); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	checklists.count = 0
+	if err := Trello2Jira(3, -1); err != nil {
+		t.Fatal(err)
+	}
+	// once for the pending fields of the bug
+	if checklists.count != 1 {
+		t.Errorf("the second run read the checklists %d times, expected once", checklists.count)
+	}
+	fields := struct {
+		Fields struct {
+			Priority struct {
+				Name string `json:"name"`
+			} `json:"priority"`
+		} `json:"fields"`
+	}{}
+	if err := xapJira.do("GET", "rest/api/2/issue/"+bug.IssueKey, nil, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields.Fields.Priority.Name != "Major" {
+		t.Errorf("the priority of %s is %q, expected the pending fields to be set", bug.IssueKey, fields.Fields.Priority.Name)
+	}
+}